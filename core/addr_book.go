@@ -0,0 +1,275 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxAddrPerMessage is the maximum number of addresses in addr command
+const MaxAddrPerMessage = 1000
+
+// AddrBookBuckets is number of buckets addresses are spread over
+const AddrBookBuckets = 64
+
+// AddrBucketSize is the maximum number of addresses in bucket, the worst address is evicted from full bucket
+const AddrBucketSize = 64
+
+// AddrSourceBuckets is number of buckets addresses advertised by single network group can fill
+const AddrSourceBuckets = 8
+
+// AddrTimePenalty is subtracted from last seen time of address advertised by another host
+const AddrTimePenalty = 2 * time.Hour
+
+// KnownAddress is peer address kept in address book
+type KnownAddress struct {
+	Addr        string
+	Services    uint64
+	LastSeen    int64
+	LastSuccess int64
+	LastAttempt int64
+	Attempts    int
+	Source      string
+}
+
+// AddrBook holds addresses of known peers and persists them to file.
+// Addresses are spread over buckets of limited size by their network group and group of peer
+// that advertised them, so single peer can fill only few buckets and evict only addresses in them.
+// It is safe for concurrent use.
+type AddrBook struct {
+	file    string
+	key     uint64
+	addrs   map[string]*KnownAddress
+	buckets []map[string]*KnownAddress
+	dirty   bool
+	mu      sync.Mutex
+}
+
+// NewAddrBook creates address book stored in node peers file
+func NewAddrBook(config Config, nodeID string) *AddrBook {
+	book := &AddrBook{file: config.GetPeersFile(nodeID), key: newNonce()}
+	book.reset()
+	return book
+}
+
+func (book *AddrBook) reset() {
+	book.addrs = make(map[string]*KnownAddress)
+	book.buckets = make([]map[string]*KnownAddress, AddrBookBuckets)
+	for i := range book.buckets {
+		book.buckets[i] = make(map[string]*KnownAddress)
+	}
+}
+
+// Load loads addresses from file
+func (book *AddrBook) Load() error {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	content, err := ioutil.ReadFile(book.file)
+	if err != nil {
+		return err
+	}
+	var addrs map[string]*KnownAddress
+	err = gob.NewDecoder(bytes.NewReader(content)).Decode(&addrs)
+	if err != nil {
+		return err
+	}
+	book.reset()
+	for _, ka := range addrs {
+		book.insertLocked(ka)
+	}
+	return nil
+}
+
+// Save saves addresses to file if they changed since last save
+func (book *AddrBook) Save() error {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	if !book.dirty {
+		return nil
+	}
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(book.addrs)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(book.file, buffer.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	book.dirty = false
+	return nil
+}
+
+// Add adds address advertised by source or refreshes its last seen time, returns true when address is new.
+// Last seen time is never later than now and is penalized when source is another host.
+func (book *AddrBook) Add(addr string, services uint64, lastSeen int64, source string) bool {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	now := time.Now().Unix()
+	if lastSeen > now {
+		lastSeen = now
+	}
+	if hostOf(source) != hostOf(addr) {
+		lastSeen -= int64(AddrTimePenalty / time.Second)
+	}
+	ka := book.addrs[addr]
+	isNew := ka == nil
+	if isNew {
+		ka = &KnownAddress{Addr: addr, Source: source}
+		book.insertLocked(ka)
+	}
+	if lastSeen > ka.LastSeen {
+		ka.LastSeen = lastSeen
+	}
+	ka.Services |= services
+	book.dirty = true
+	return isNew
+}
+
+// Attempt records connection attempt to address
+func (book *AddrBook) Attempt(addr string) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	ka := book.addrs[addr]
+	if ka == nil {
+		return
+	}
+	ka.LastAttempt = time.Now().Unix()
+	ka.Attempts++
+	book.dirty = true
+}
+
+// Good records successful connection to address
+func (book *AddrBook) Good(addr string) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	ka := book.addrs[addr]
+	if ka == nil {
+		ka = &KnownAddress{Addr: addr, Source: addr}
+		book.insertLocked(ka)
+	}
+	now := time.Now().Unix()
+	ka.LastSeen = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+	book.dirty = true
+}
+
+// Len gets number of known addresses
+func (book *AddrBook) Len() int {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	return len(book.addrs)
+}
+
+// Select gets up to n addresses to connect to, best candidates first.
+// Addresses with recent successful connections and fewer failed attempts are preferred.
+func (book *AddrBook) Select(n int, exclude func(addr string) bool) []string {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	var candidates []*KnownAddress
+	for _, ka := range book.addrs {
+		if exclude == nil || !exclude(ka.Addr) {
+			candidates = append(candidates, ka)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Attempts != b.Attempts {
+			return a.Attempts < b.Attempts
+		}
+		if a.LastSuccess != b.LastSuccess {
+			return a.LastSuccess > b.LastSuccess
+		}
+		return a.LastSeen > b.LastSeen
+	})
+	var addrs []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		addrs = append(addrs, candidates[i].Addr)
+	}
+	return addrs
+}
+
+// Addresses gets up to max most recently seen addresses
+func (book *AddrBook) Addresses(max int) []KnownAddress {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	var addrs []KnownAddress
+	for _, ka := range book.addrs {
+		addrs = append(addrs, *ka)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].LastSeen > addrs[j].LastSeen
+	})
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+	return addrs
+}
+
+// insertLocked adds address to its bucket, the worst address is evicted when bucket is full
+func (book *AddrBook) insertLocked(ka *KnownAddress) {
+	bucket := book.buckets[book.bucket(ka.Addr, ka.Source)]
+	if len(bucket) >= AddrBucketSize {
+		var worst *KnownAddress
+		for _, other := range bucket {
+			if worst == nil || worseThan(other, worst) {
+				worst = other
+			}
+		}
+		delete(bucket, worst.Addr)
+		delete(book.addrs, worst.Addr)
+	}
+	bucket[ka.Addr] = ka
+	book.addrs[ka.Addr] = ka
+}
+
+// bucket gets bucket of address advertised by source.
+// Addresses from one source group fall into at most AddrSourceBuckets buckets.
+func (book *AddrBook) bucket(addr, source string) int {
+	sourceGroup := addrGroup(source)
+	inner := book.hash(sourceGroup, addrGroup(addr)) % AddrSourceBuckets
+	var buff [8]byte
+	binary.BigEndian.PutUint64(buff[:], inner)
+	return int(book.hash(sourceGroup, string(buff[:])) % AddrBookBuckets)
+}
+
+// hash gets keyed hash of values, key is random so buckets can't be predicted by peers
+func (book *AddrBook) hash(values ...string) uint64 {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, book.key)
+	for _, value := range values {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// worseThan checks if address a is worse to keep than b: addresses never connected to
+// are worse than those connected to, then the ones seen earlier are worse
+func worseThan(a, b *KnownAddress) bool {
+	if (a.LastSuccess == 0) != (b.LastSuccess == 0) {
+		return a.LastSuccess == 0
+	}
+	return a.LastSeen < b.LastSeen
+}
+
+// addrGroup gets network group of address: /16 of IPv4 address, /32 of IPv6 address, otherwise host
+func addrGroup(addr string) string {
+	host := hostOf(addr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrBookSaveLoad(t *testing.T) {
+	book := NewAddrBook(&EnvConfig{}, "1")
+	book.file = filepath.Join(t.TempDir(), "peers.dat")
+	assert.True(t, book.Add("localhost:3001", ServiceNodeNetwork, 100, "localhost:3001"))
+	assert.False(t, book.Add("localhost:3001", ServiceNodeMining, 200, "localhost:3001"))
+	book.Good("localhost:3002")
+	assert.Nil(t, book.Save())
+
+	loaded := NewAddrBook(&EnvConfig{}, "1")
+	loaded.file = book.file
+	assert.Nil(t, loaded.Load())
+	assert.Equal(t, 2, loaded.Len())
+	addrs := loaded.Addresses(MaxAddrPerMessage)
+	assert.Equal(t, "localhost:3002", addrs[0].Addr)
+	assert.NotZero(t, addrs[0].LastSuccess)
+	assert.Equal(t, "localhost:3001", addrs[1].Addr)
+	assert.Equal(t, int64(200), addrs[1].LastSeen)
+	assert.Equal(t, ServiceNodeNetwork|ServiceNodeMining, addrs[1].Services)
+}
+
+func TestAddrBookSelect(t *testing.T) {
+	book := NewAddrBook(&EnvConfig{}, "1")
+	book.Add("localhost:3001", ServiceNodeNetwork, 100, "localhost:3001")
+	book.Add("localhost:3002", ServiceNodeNetwork, 200, "localhost:3002")
+	book.Add("localhost:3003", ServiceNodeNetwork, 300, "localhost:3003")
+	book.Attempt("localhost:3003")
+	exclude := func(addr string) bool { return addr == "localhost:3001" }
+	assert.Equal(t, []string{"localhost:3002", "localhost:3003"}, book.Select(5, exclude))
+	assert.Equal(t, []string{"localhost:3002"}, book.Select(1, exclude))
+}
+
+func TestAddrBookClampsLastSeen(t *testing.T) {
+	book := NewAddrBook(&EnvConfig{}, "1")
+	future := time.Now().Add(time.Hour).Unix()
+	book.Add("10.0.0.1:3001", ServiceNodeNetwork, future, "10.0.0.1:50001")
+	book.Add("10.0.0.2:3001", ServiceNodeNetwork, future, "10.1.0.1:50001")
+	addrs := book.Addresses(MaxAddrPerMessage)
+	assert.Equal(t, "10.0.0.1:3001", addrs[0].Addr)
+	assert.True(t, addrs[0].LastSeen <= time.Now().Unix())
+	assert.True(t, addrs[1].LastSeen <= time.Now().Add(-AddrTimePenalty).Unix())
+}
+
+func TestAddrBookSourceBuckets(t *testing.T) {
+	book := NewAddrBook(&EnvConfig{}, "1")
+	for i := 0; i < 4*AddrSourceBuckets*AddrBucketSize; i++ {
+		addr := fmt.Sprintf("10.%d.%d.1:3001", i/256, i%256)
+		book.Add(addr, ServiceNodeNetwork, int64(i), "192.168.0.1:50001")
+	}
+	assert.True(t, book.Len() <= AddrSourceBuckets*AddrBucketSize)
+	book.Good("172.16.0.1:3001")
+	assert.Equal(t, "172.16.0.1:3001", book.Addresses(1)[0].Addr)
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// BanList holds banned peer addresses and persists them to file.
+// Address is either host or host:port, banned host bans all its ports.
+// It is safe for concurrent use.
+type BanList struct {
+	file string
+	bans map[string]int64
+	mu   sync.Mutex
+}
+
+// BanEntry describes single ban
+type BanEntry struct {
+	Addr  string
+	Until int64
+}
+
+// NewBanList creates ban list stored in node bans file
+func NewBanList(config Config, nodeID string) *BanList {
+	return &BanList{file: config.GetBansFile(nodeID), bans: make(map[string]int64)}
+}
+
+// Load loads bans from file
+func (bl *BanList) Load() error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	content, err := ioutil.ReadFile(bl.file)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(content)).Decode(&bl.bans)
+}
+
+// Save saves bans to file
+func (bl *BanList) Save() error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(bl.bans)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bl.file, buffer.Bytes(), 0644)
+}
+
+// Ban bans address for given duration
+func (bl *BanList) Ban(addr string, duration time.Duration) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.bans[addr] = time.Now().Add(duration).Unix()
+}
+
+// Unban removes ban of address, returns false when address was not banned
+func (bl *BanList) Unban(addr string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	_, ok := bl.bans[addr]
+	delete(bl.bans, addr)
+	return ok
+}
+
+// IsBanned checks if address or its host is banned
+func (bl *BanList) IsBanned(addr string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if bl.bannedLocked(addr) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && bl.bannedLocked(host)
+}
+
+func (bl *BanList) bannedLocked(addr string) bool {
+	until, ok := bl.bans[addr]
+	if !ok {
+		return false
+	}
+	if time.Now().Unix() >= until {
+		delete(bl.bans, addr)
+		return false
+	}
+	return true
+}
+
+// Bans gets all active bans
+func (bl *BanList) Bans() []BanEntry {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	var entries []BanEntry
+	for addr, until := range bl.bans {
+		if bl.bannedLocked(addr) {
+			entries = append(entries, BanEntry{addr, until})
+		}
+	}
+	return entries
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanListHostAndExpiry(t *testing.T) {
+	bans := NewBanList(&EnvConfig{}, "1")
+	bans.Ban("localhost:3001", time.Hour)
+	bans.Ban("10.0.0.1", time.Hour)
+	bans.Ban("localhost:3002", -time.Second)
+	assert.True(t, bans.IsBanned("localhost:3001"))
+	assert.False(t, bans.IsBanned("localhost:3003"))
+	assert.True(t, bans.IsBanned("10.0.0.1:51234"))
+	assert.False(t, bans.IsBanned("localhost:3002"))
+	assert.Len(t, bans.Bans(), 2)
+	assert.True(t, bans.Unban("10.0.0.1"))
+	assert.False(t, bans.Unban("10.0.0.1"))
+	assert.False(t, bans.IsBanned("10.0.0.1:51234"))
+}
+
+func TestBanListSaveLoad(t *testing.T) {
+	bans := NewBanList(&EnvConfig{}, "1")
+	bans.file = filepath.Join(t.TempDir(), "bans.dat")
+	bans.Ban("localhost:3001", time.Hour)
+	assert.Nil(t, bans.Save())
+
+	loaded := NewBanList(&EnvConfig{}, "1")
+	loaded.file = bans.file
+	assert.Nil(t, loaded.Load())
+	assert.True(t, loaded.IsBanned("localhost:3001"))
+}
@@ -2,8 +2,10 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
+	"runtime"
 	"time"
 )
 
@@ -15,20 +17,23 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	Difficulty    int
+	Signer        []byte
+	Signature     []byte
 }
 
-// NewBlock creates new block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
-	block.POW()
+// NewBlock creates new block with hash meeting difficulty, proof of work uses all cpus
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height, difficulty int) *Block {
+	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height, difficulty, nil, nil}
+	block.POW(context.Background(), runtime.NumCPU())
 	return block
 }
 
-// HashTransactions makes hash of all transaction ids
+// HashTransactions makes merkle root of transaction hashes
 func (block *Block) HashTransactions() []byte {
 	var hashes [][]byte
 	for _, transaction := range block.Transactions {
-		hashes = append(hashes, transaction.Serialize())
+		hashes = append(hashes, transaction.Hash())
 	}
 	if len(hashes) == 0 {
 		return []byte{}
@@ -50,21 +55,41 @@ func (block *Block) Serialize() []byte {
 
 // Deserialize deserializes bytes to block
 func Deserialize(data []byte) *Block {
+	block, err := DecodeBlock(data)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+// DecodeBlock deserializes block received from network, data larger than maximum block size is rejected before decoding
+func (params *ChainParams) DecodeBlock(data []byte) (*Block, error) {
+	if len(data) > params.MaxBlockSize {
+		return nil, fmt.Errorf("block of %d bytes exceeds maximum block size %d", len(data), params.MaxBlockSize)
+	}
+	return DecodeBlock(data)
+}
+
+// DecodeBlock deserializes bytes to block, returns error for malformed data
+func DecodeBlock(data []byte) (*Block, error) {
 	var block Block
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&block)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &block
+	return &block, nil
 }
 
 // Log prints block info
 func (block *Block) Log() {
 	template := "BLOCK >>>> \nHeight: %d \nPrevious hash: %x \nData: %x " +
-		"\nTimestamp: %d [%s] \nNonce: %d \nTransactions:\n"
+		"\nTimestamp: %d [%s] \nNonce: %d \nDifficulty: %d \nTransactions:\n"
 	fmt.Printf(template, block.Height, block.PrevBlockHash, block.Hash,
-		block.Timestamp, time.Unix(block.Timestamp, 0), block.Nonce)
+		block.Timestamp, time.Unix(block.Timestamp, 0), block.Nonce, block.Difficulty)
+	if len(block.Signer) > 0 {
+		fmt.Printf("Signer: %x\n", block.Signer)
+	}
 	for _, t := range block.Transactions {
 		t.Log()
 	}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// BlockTemplate is candidate block built from pending transactions on top of chain tip.
+// Transactions spend outputs of main chain or of transactions before them, coinbase comes last
+// and collects block reward with fees of selected transactions.
+type BlockTemplate struct {
+	PrevBlockHash []byte
+	Height        int
+	Difficulty    int
+	Timestamp     int64
+	MinTimestamp  int64
+	Transactions  []*Transaction
+	Fees          int
+	Size          int
+}
+
+// BlockHeaderReserve is space of block size limit kept for block fields besides transactions
+const BlockHeaderReserve = 1000
+
+// NewBlockTemplate selects pending transactions fitting into maxBytes with coinbase paying
+// block subsidy and their fees to address, coinbase data holds block height so its id is unique.
+// Expired transactions are dropped from pool before selection.
+// Size and transaction count stay within consensus limits of network.
+func NewBlockTemplate(chain *Blockchain, pool *Mempool, address string, maxBytes int) *BlockTemplate {
+	if limit := chain.Params.MaxBlockSize - BlockHeaderReserve; maxBytes > limit {
+		maxBytes = limit
+	}
+	tip, height := chain.Tip()
+	template := &BlockTemplate{PrevBlockHash: tip, Height: height + 1, Difficulty: chain.Difficulty()}
+	template.Timestamp = chain.NextTimestamp(tip)
+	template.MinTimestamp = chain.MedianTimePast(tip) + 1
+	reward := chain.Params.BlockSubsidy(template.Height)
+	data := fmt.Sprintf("height %d", template.Height)
+	coinbase := NewCoinbaseTransaction(address, data, reward)
+	pool.Expire(time.Now())
+	for _, entry := range pool.SelectTransactions(maxBytes-len(coinbase.Serialize()), chain.Params.MaxBlockTransactions-1) {
+		tx := entry.Tx
+		template.Transactions = append(template.Transactions, &tx)
+		template.Fees += entry.Fee
+		template.Size += entry.Size
+	}
+	coinbase = NewCoinbaseTransaction(address, data, reward+template.Fees)
+	template.Transactions = append(template.Transactions, coinbase)
+	template.Size += len(coinbase.Serialize())
+	return template
+}
+
+// NewBlock makes block from template with given timestamp and nonce
+func (template *BlockTemplate) NewBlock(timestamp int64, nonce int) *Block {
+	block := &Block{timestamp, template.Transactions, template.PrevBlockHash, []byte{}, nonce, template.Height, template.Difficulty, nil, nil}
+	block.Hash = block.computeHash()
+	return block
+}
+
+// Log prints block template info
+func (template *BlockTemplate) Log() {
+	fmt.Printf("block template [height:%d transactions:%d size:%d fees:%d]\n",
+		template.Height, len(template.Transactions), template.Size, template.Fees)
+}
+
+// SelectTransactions gets at most maxCount pending transactions for block of at most maxBytes.
+// Transactions are selected as packages with their unselected pending ancestors, ordered by
+// package fee rate, so high fee child pays for its low fee parent. Parents always come before
+// transactions spending their outputs.
+// Package fee and size of every transaction are computed once and reduced as its ancestors
+// are selected, candidates are kept in heap by package fee rate.
+func (mp *Mempool) SelectTransactions(maxBytes, maxCount int) []MempoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	packages := make(map[string]*txPackage)
+	children := make(map[string][]string)
+	candidates := &packageHeap{}
+	for id, entry := range mp.entries {
+		pkg := &txPackage{id: id, seq: entry.seq}
+		for _, e := range mp.ancestors(entry, nil) {
+			pkg.fee += e.Fee
+			pkg.size += e.Size
+			pkg.count++
+		}
+		packages[id] = pkg
+		heap.Push(candidates, *pkg)
+		for _, vin := range entry.Tx.Vin {
+			parent := hex.EncodeToString(vin.Txid)
+			if mp.entries[parent] != nil && !contains(children[parent], id) {
+				children[parent] = append(children[parent], id)
+			}
+		}
+	}
+	selected := make(map[string]bool)
+	var entries []MempoolEntry
+	size := 0
+	for candidates.Len() > 0 && len(entries) < maxCount {
+		pkg := heap.Pop(candidates).(txPackage)
+		if selected[pkg.id] || pkg.version != packages[pkg.id].version {
+			continue
+		}
+		// package that does not fit is dropped, its version updated after ancestors
+		// are selected is pushed again and checked on its own
+		if size+pkg.size > maxBytes || len(entries)+pkg.count > maxCount {
+			continue
+		}
+		for _, entry := range mp.ancestors(mp.entries[pkg.id], selected) {
+			id := hex.EncodeToString(entry.Tx.ID)
+			selected[id] = true
+			entries = append(entries, *entry)
+			for _, descendant := range descendants(id, children) {
+				if selected[descendant] {
+					continue
+				}
+				d := packages[descendant]
+				d.fee -= entry.Fee
+				d.size -= entry.Size
+				d.count--
+				d.version++
+				heap.Push(candidates, *d)
+			}
+		}
+		size += pkg.size
+	}
+	return entries
+}
+
+// txPackage is pending transaction with fee, size and count summed over it and its unselected ancestors.
+// Version changes with every update so outdated copies in heap are ignored.
+type txPackage struct {
+	id      string
+	seq     uint64
+	fee     int
+	size    int
+	count   int
+	version int
+}
+
+// packageHeap orders packages by fee rate, transactions added to mempool earlier first when rates are equal
+type packageHeap []txPackage
+
+func (h packageHeap) Len() int { return len(h) }
+
+func (h packageHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.fee*b.size != b.fee*a.size {
+		return a.fee*b.size > b.fee*a.size
+	}
+	return a.seq < b.seq
+}
+
+func (h packageHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *packageHeap) Push(x interface{}) { *h = append(*h, x.(txPackage)) }
+
+func (h *packageHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// descendants gets ids of all pending transactions spending outputs of transaction, directly or through others
+func descendants(id string, children map[string][]string) []string {
+	var result []string
+	visited := make(map[string]bool)
+	queue := append([]string{}, children[id]...)
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = queue[1:]
+		if visited[child] {
+			continue
+		}
+		visited[child] = true
+		result = append(result, child)
+		queue = append(queue, children[child]...)
+	}
+	return result
+}
+
+// ancestors gets package of entry with its pending ancestors not in selected, parents come first
+func (mp *Mempool) ancestors(entry *MempoolEntry, selected map[string]bool) []*MempoolEntry {
+	var pkg []*MempoolEntry
+	visited := make(map[string]bool)
+	var visit func(entry *MempoolEntry)
+	visit = func(entry *MempoolEntry) {
+		id := hex.EncodeToString(entry.Tx.ID)
+		if visited[id] || selected[id] {
+			return
+		}
+		visited[id] = true
+		for _, vin := range entry.Tx.Vin {
+			if parent := mp.entries[hex.EncodeToString(vin.Txid)]; parent != nil {
+				visit(parent)
+			}
+		}
+		pkg = append(pkg, entry)
+	}
+	visit(entry)
+	return pkg
+}
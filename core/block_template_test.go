@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockTemplateChildPaysForParent(t *testing.T) {
+	chain := newTestChain(t)
+	wallet, miner := NewWallet(), NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+
+	parent := newTestTx(wallet, cb1, 0, *NewTxOutput(50, address))
+	other := newTestTx(wallet, cb2, 0, *NewTxOutput(47, address))
+	child := newTestTx(wallet, parent, 0, *NewTxOutput(40, address))
+	for _, tx := range []*Transaction{parent, other, child} {
+		assert.Nil(t, pool.Add(*tx))
+	}
+
+	full := NewBlockTemplate(chain, pool, string(miner.GetAddress()), 1<<20)
+	assert.Equal(t, 4, len(full.Transactions))
+	assert.Equal(t, parent.ID, full.Transactions[0].ID)
+	assert.Equal(t, child.ID, full.Transactions[1].ID)
+	assert.Equal(t, other.ID, full.Transactions[2].ID)
+	assert.Equal(t, 13, full.Fees)
+
+	_, height := chain.Tip()
+	coinbase := NewCoinbaseTransaction(string(miner.GetAddress()), fmt.Sprintf("height %d", height+1), 50)
+	maxBytes := len(coinbase.Serialize()) + len(parent.Serialize()) + len(child.Serialize()) + 10
+	template := NewBlockTemplate(chain, pool, string(miner.GetAddress()), maxBytes)
+	assert.Equal(t, 3, len(template.Transactions))
+	assert.Equal(t, 10, template.Fees)
+	assert.True(t, template.Size <= maxBytes)
+	cbTx := template.Transactions[2]
+	assert.True(t, cbTx.IsCoinbase())
+	assert.Equal(t, 60, cbTx.Vout[0].Value)
+
+	chain.Subscribe(pool.Update)
+	_, err := chain.MineBlock(template.Transactions)
+	assert.Nil(t, err)
+	assert.Equal(t, []Transaction{*other}, pool.Txs())
+	utxos := UtxoStore{chain}
+	assert.Nil(t, utxos.Reindex())
+	_, ok := utxos.FindOutput(parent.ID, 0)
+	assert.False(t, ok)
+	out, ok := utxos.FindOutput(child.ID, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 40, out.Value)
+}
+
+func TestBlockTemplateConsensusLimits(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+	low := newTestTx(wallet, cb1, 0, *NewTxOutput(49, address))
+	high := newTestTx(wallet, cb2, 0, *NewTxOutput(45, address))
+	assert.Nil(t, pool.Add(*low))
+	assert.Nil(t, pool.Add(*high))
+
+	params := *chain.Params
+	params.MaxBlockTransactions = 2
+	chain.Params = &params
+	template := NewBlockTemplate(chain, pool, address, 1<<20)
+	assert.Equal(t, 2, len(template.Transactions))
+	assert.Equal(t, high.ID, template.Transactions[0].ID)
+
+	tip, height := chain.Tip()
+	block := &Block{chain.NextTimestamp(tip), []*Transaction{low, high, template.Transactions[1]}, tip, []byte{}, 0, height + 1, 0, nil, nil}
+	assert.Nil(t, chain.Engine.Prepare(chain, block))
+	assert.Nil(t, chain.Engine.Seal(context.Background(), block))
+	err := chain.ValidateBlock(block)
+	assert.True(t, isProtocolError(err))
+	assert.Contains(t, err.Error(), "maximum is 2")
+
+	params.MaxBlockTransactions = 10
+	params.MaxBlockSize = len(block.Serialize()) - 1
+	err = chain.ValidateBlock(block)
+	assert.True(t, isProtocolError(err))
+	assert.Contains(t, err.Error(), "block size")
+	_, err = params.DecodeBlock(block.Serialize())
+	assert.NotNil(t, err)
+
+	params.MaxTxSize = len(high.Serialize()) - 1
+	assert.NotNil(t, CheckTransactionSanity(high, chain.Params))
+	_, err = params.DecodeTransaction(high.Serialize())
+	assert.NotNil(t, err)
+	assert.Equal(t, uint32(params.MaxTxSize+MaxControlPayload), params.MaxPayload("transaction"))
+	assert.Equal(t, uint32(params.MaxBlockSize+MaxControlPayload), params.MaxPayload("block"))
+}
+
+func TestBlockTemplateUpdatesDescendantPackages(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+
+	parent := newTestTx(wallet, cb1, 0, *NewTxOutput(25, address), *NewTxOutput(25, address))
+	rich := newTestTx(wallet, parent, 0, *NewTxOutput(1, address))
+	sibling := newTestTx(wallet, parent, 1, *NewTxOutput(17, address))
+	other := newTestTx(wallet, cb2, 0, *NewTxOutput(40, address))
+	for _, tx := range []*Transaction{parent, rich, sibling, other} {
+		assert.Nil(t, pool.Add(*tx))
+	}
+
+	entries := pool.SelectTransactions(1<<20, 10)
+	var ids [][]byte
+	for _, entry := range entries {
+		ids = append(ids, entry.Tx.ID)
+	}
+	assert.Equal(t, [][]byte{parent.ID, rich.ID, other.ID, sibling.ID}, ids)
+	single := pool.SelectTransactions(1<<20, 1)
+	assert.Len(t, single, 1)
+	assert.Equal(t, other.ID, single[0].Tx.ID)
+}
+
+func TestBlockTemplateSelectsChildAfterParent(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+
+	parent := newTestTx(wallet, cb1, 0, *NewTxOutput(25, address), *NewTxOutput(25, address))
+	rich := newTestTx(wallet, parent, 0, *NewTxOutput(1, address))
+	child := newTestTx(wallet, parent, 1, *NewTxOutput(10, address))
+	other := newTestTx(wallet, cb2, 0, *NewTxOutput(40, address))
+	for _, tx := range []*Transaction{parent, rich, child, other} {
+		assert.Nil(t, pool.Add(*tx))
+	}
+
+	maxBytes := len(parent.Serialize()) + len(rich.Serialize()) + len(child.Serialize())
+	var ids [][]byte
+	for _, entry := range pool.SelectTransactions(maxBytes, 10) {
+		ids = append(ids, entry.Tx.ID)
+	}
+	assert.Equal(t, [][]byte{parent.ID, rich.ID, child.ID}, ids)
+	ids = nil
+	for _, entry := range pool.SelectTransactions(1<<20, 3) {
+		ids = append(ids, entry.Tx.ID)
+	}
+	assert.Equal(t, [][]byte{parent.ID, rich.ID, child.ID}, ids)
+}
@@ -16,8 +16,8 @@ func TestSerializeDeserialize(t *testing.T) {
 	a2 := "VkF377DV5khkmXjw35PnWH1frXKc1vVQS"
 	a2Pub := "a3c6ee8418a14ed252438dd40f072b3b874742e2839a2a58542432014a6323213cb9208a76f2ce44361456941cee1ca0cf23a5a126e204e7cc7009a50cb69c09"
 
-	txin1 := TxInput{[]byte("tx1"), 0, nil, []byte(a1)}
-	txin2 := TxInput{[]byte("tx2"), 0, nil, []byte(a2)}
+	txin1 := TxInput{[]byte("tx1"), 0, nil, []byte(a1), SequenceFinal}
+	txin2 := TxInput{[]byte("tx2"), 0, nil, []byte(a2), SequenceFinal}
 
 	txout1 := TxOutput{100, []byte(a1Pub)}
 	txout2 := TxOutput{200, []byte(a2Pub)}
@@ -25,8 +25,8 @@ func TestSerializeDeserialize(t *testing.T) {
 
 	t1 := &Transaction{[]byte(nil), []TxInput{txin1, txin2}, []TxOutput{txout1, txout2, txout3}}
 
-	block1 := NewBlock([]*Transaction{}, nil, 1)
-	block2 := NewBlock([]*Transaction{t1}, block1.Hash, 2)
+	block1 := NewBlock([]*Transaction{}, nil, 1, Difficulty)
+	block2 := NewBlock([]*Transaction{t1}, block1.Hash, 2, Difficulty)
 
 	block := Deserialize(block2.Serialize())
 
@@ -35,6 +35,7 @@ func TestSerializeDeserialize(t *testing.T) {
 	assert.Equal(t, block2.Hash, block.Hash)
 	assert.Equal(t, block2.Nonce, block.Nonce)
 	assert.Equal(t, block2.Height, block.Height)
+	assert.Equal(t, block2.Difficulty, block.Difficulty)
 
 	for i, tx := range block.Transactions {
 		tx2 := block2.Transactions[i]
@@ -47,10 +48,45 @@ func TestSerializeDeserialize(t *testing.T) {
 }
 
 func DemoTransaction() *Transaction {
-	txin1 := TxInput{[]byte("tx1"), 0, nil, []byte("script1")}
-	txin2 := TxInput{[]byte("tx1"), 0, nil, []byte("script1")}
+	txin1 := TxInput{[]byte("tx1"), 0, nil, []byte("script1"), SequenceFinal}
+	txin2 := TxInput{[]byte("tx1"), 0, nil, []byte("script1"), SequenceFinal}
 	txout1 := TxOutput{100, []byte("address1")}
 	txout2 := TxOutput{200, []byte("address2")}
 	txout3 := TxOutput{300, []byte("address3")}
 	return &Transaction{[]byte{}, []TxInput{txin1, txin2}, []TxOutput{txout1, txout2, txout3}}
 }
+
+func TestValidateBlockInputs(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := fundTestWallet(t, chain, wallet, "inputs")
+	tip := chain.Iterator().Next()
+	coinbase := func(data string) *Transaction { return NewCoinbaseTransaction(address, data, 50) }
+
+	spend := newTestTx(wallet, cbTx, 0, *NewTxOutput(50, address))
+	child := newTestTx(wallet, spend, 0, *NewTxOutput(50, address))
+	block := newTestBlock(t, chain, tip, chain.NextTimestamp(tip.Hash), spend, child, coinbase("a"))
+	assert.Nil(t, chain.ValidateBlock(block))
+
+	double := newTestTx(wallet, cbTx, 0, *NewTxOutput(49, address))
+	block = newTestBlock(t, chain, tip, chain.NextTimestamp(tip.Hash), spend, double, coinbase("b"))
+	assert.Contains(t, chain.ValidateBlock(block).Error(), "spends missing or spent output")
+
+	forged := newTestTx(wallet, cbTx, 0, *NewTxOutput(50, address))
+	forged.ID = child.ID
+	block = newTestBlock(t, chain, tip, chain.NextTimestamp(tip.Hash), forged, coinbase("c"))
+	assert.Contains(t, chain.ValidateBlock(block).Error(), "transaction id is not its hash")
+
+	block = newTestBlock(t, chain, tip, chain.NextTimestamp(tip.Hash), cbTx)
+	assert.Contains(t, chain.ValidateBlock(block).Error(), "overwrites unspent outputs")
+
+	spent := newTestBlock(t, chain, tip, chain.NextTimestamp(tip.Hash), spend, coinbase("d"))
+	assert.Nil(t, chain.ValidateBlock(spent))
+	chain.AddBlock(spent)
+	block = newTestBlock(t, chain, spent, chain.NextTimestamp(spent.Hash), double, coinbase("e"))
+	assert.Contains(t, chain.ValidateBlock(block).Error(), "spends missing or spent output")
+
+	fork := newTestBlock(t, chain, tip, spent.Timestamp+1, double, coinbase("f"))
+	assert.Nil(t, chain.ValidateBlock(fork))
+}
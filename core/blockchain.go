@@ -2,24 +2,49 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/mr-tron/base58/base58"
 )
 
-// Blockchain data structure
+// Blockchain data structure.
+// Tip and best height are guarded by mu, block writes are serialized with it
+// and utxo index is updated in the same database transaction as the tip.
+// Engine of network consensus seals and verifies blocks and decides which chain is the best,
+// Params are parameters of network of chain, Time gives network adjusted time used to validate block timestamps.
+// With AssumeValid signatures of blocks below assume valid block of network are not checked during initial sync,
+// such blocks are kept in unverified and join main chain only together with assume valid block.
+// When assume valid block joins, unverified blocks left are not its ancestors and are forgotten,
+// forks disconnecting assume valid block are not joined so these blocks never join main chain.
 type Blockchain struct {
-	tip        []byte
-	db         *bolt.DB
-	bestHeight int
-	config     Config
-	ws         WalletStore
+	Params      *ChainParams
+	Engine      ConsensusEngine
+	Time        *TimeSource
+	AssumeValid bool
+	unverified  map[string]bool
+	tip         []byte
+	db          *bolt.DB
+	bestHeight  int
+	config      Config
+	ws          WalletStore
+	subscribers []func(ChainUpdate)
+	mu          sync.RWMutex
+}
+
+// ChainUpdate lists blocks leaving main chain, from old tip down to fork point,
+// and blocks joining it in ascending order
+type ChainUpdate struct {
+	Disconnected []*Block
+	Connected    []*Block
 }
 
 // BlockchainIterator iterates over blocks
@@ -29,28 +54,51 @@ type BlockchainIterator struct {
 	config      Config
 }
 
-// InitChain makes new blockchain
-func InitChain(config Config, address string, nodeID string) *Blockchain {
+// InitChain makes new blockchain starting with genesis block of configured network,
+// existing chain is opened and its genesis is checked against network
+func InitChain(config Config, nodeID string) *Blockchain {
 	var tip []byte
+	params := chainParams(config)
 	ws := NewWalletStore(config, nodeID)
 	ws.Load(config.GetWalletStoreFile(nodeID))
 	db, err := bolt.Open(config.GetDbFile(nodeID), 0600, nil)
+	if err != nil {
+		panic(err)
+	}
 	err = db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(config.GetDbBucket()))
 		if b == nil {
-			ts := NewCoinbaseTransaction(address, config.GetGenesisData(), config.GetBlockReward())
-			gen := NewBlock([]*Transaction{ts}, []byte{}, 0)
-			b, err = tx.CreateBucket([]byte(config.GetDbBucket()))
+			gen := params.GenesisBlock()
+			if !params.IsGenesis(gen.Hash) || !gen.ValidatePOW() {
+				return fmt.Errorf("genesis block %x does not match %s network", gen.Hash, params.Name)
+			}
+			b, err := tx.CreateBucket([]byte(config.GetDbBucket()))
+			if err != nil {
+				return err
+			}
 			err = b.Put(gen.Hash, gen.Serialize())
-			err = b.Put([]byte("1"), gen.Hash)
+			if err != nil {
+				return err
+			}
 			tip = gen.Hash
 			fmt.Printf("bucket %s created [nodeID:%s]\n", config.GetDbBucket(), nodeID)
-		} else {
-			tip = b.Get([]byte("1"))
+			return b.Put([]byte("1"), gen.Hash)
 		}
+		tip = b.Get([]byte("1"))
 		return nil
 	})
-	chain := &Blockchain{tip, db, 0, config, *ws}
+	if err != nil {
+		panic(err)
+	}
+	bestHeight := GetBestHeight(db, config)
+	engine, err := NewConsensusEngine(params, config.GetAuthorities())
+	if err != nil {
+		panic(err)
+	}
+	chain := &Blockchain{Params: params, Engine: engine, Time: NewTimeSource(), AssumeValid: true, unverified: make(map[string]bool), tip: tip, db: db, bestHeight: bestHeight, config: config, ws: *ws}
+	if genesis := chain.Genesis(); !params.IsGenesis(genesis.Hash) {
+		panic(fmt.Errorf("chain genesis %x does not match %s network", genesis.Hash, params.Name))
+	}
 	utxos := UtxoStore{chain}
 	fmt.Printf("chain initialized [nodeID:%s] \n", nodeID)
 	utxos.Reindex()
@@ -58,6 +106,15 @@ func InitChain(config Config, address string, nodeID string) *Blockchain {
 	return chain
 }
 
+// chainParams gets parameters of configured network with configured checkpoints and assume valid block
+func chainParams(config Config) *ChainParams {
+	params, err := ParamsForNetwork(config.GetNetwork())
+	if err != nil {
+		panic(err)
+	}
+	return params.WithCheckpoints(config.GetCheckpoints(), config.GetAssumeValid())
+}
+
 // GetBestHeight gets the max block height
 func GetBestHeight(db *bolt.DB, config Config) int {
 	var lastBlock Block
@@ -95,37 +152,84 @@ func GetChain(config Config, nodeID string) *Blockchain {
 		panic(err)
 	}
 	bestHeight := GetBestHeight(db, config)
-	return &Blockchain{tip, db, bestHeight, config, *ws}
+	params := chainParams(config)
+	engine, err := NewConsensusEngine(params, config.GetAuthorities())
+	if err != nil {
+		panic(err)
+	}
+	return &Blockchain{Params: params, Engine: engine, Time: NewTimeSource(), AssumeValid: true, unverified: make(map[string]bool), tip: tip, db: db, bestHeight: bestHeight, config: config, ws: *ws}
 }
 
-// MineBlock adds given data as new block in chain
+// MineBlock adds given data as new block in chain.
+// Block is sealed by consensus engine without holding chain lock, block becomes tip unless
+// a better chain was added in the meantime.
 func (chain *Blockchain) MineBlock(ts []*Transaction) (*Block, error) {
-	var tip []byte
-	for _, tx := range ts {
-		if chain.VerifyTransaction(tx) != true {
+	tip, bestHeight := chain.Tip()
+	for i, tx := range ts {
+		if tx.Verify(chain.getBlockPreviousTransactions(tip, ts, i)) != true {
 			return nil, fmt.Errorf("invalid transaction found [txid:%x]", tx.ID)
 		}
 	}
-	err := chain.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(chain.config.GetDbBucket()))
-		tip = b.Get([]byte("1"))
-		return nil
-	})
-	newBestHeight := GetBestHeight(chain.db, chain.config) + 1
-	block := NewBlock(ts, tip, newBestHeight)
-	err = chain.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(chain.config.GetDbBucket()))
-		err = b.Put(block.Hash, block.Serialize())
-		err = b.Put([]byte("1"), block.Hash)
-		chain.tip = block.Hash
-		return nil
-	})
-	chain.bestHeight = newBestHeight
-	return block, err
+	block := &Block{chain.NextTimestamp(tip), ts, tip, []byte{}, 0, bestHeight + 1, 0, nil, nil}
+	err := chain.Engine.Prepare(chain, block)
+	if err != nil {
+		return nil, err
+	}
+	err = chain.Engine.Seal(context.Background(), block)
+	if err != nil {
+		return nil, err
+	}
+	chain.AddBlock(block)
+	return block, nil
+}
+
+// Difficulty gets proof of work difficulty required for blocks of chain
+func (chain *Blockchain) Difficulty() int {
+	return chain.Params.Difficulty
 }
 
-// AddBlock adds prepared block to chain
+// MedianTimePast gets median timestamp of MedianTimeSpan blocks ending with block of given hash
+func (chain *Blockchain) MedianTimePast(hash []byte) int64 {
+	var timestamps []int64
+	for len(timestamps) < MedianTimeSpan && len(hash) > 0 {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			break
+		}
+		timestamps = append(timestamps, block.Timestamp)
+		hash = block.PrevBlockHash
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// NextTimestamp gets timestamp for block built on top of prev: network adjusted time,
+// moved after median time past when clock is behind previous blocks
+func (chain *Blockchain) NextTimestamp(prev []byte) int64 {
+	timestamp := chain.Time.Now().Unix()
+	if mtp := chain.MedianTimePast(prev); timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+	return timestamp
+}
+
+// Tip gets hash and height of the last block
+func (chain *Blockchain) Tip() ([]byte, int) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return chain.tip, chain.bestHeight
+}
+
+// AddBlock adds prepared block to chain.
+// Block becomes new tip when its chain weighs more than current main chain since their fork point,
+// utxo index follows the switch
+// and subscribers are notified of connected and disconnected blocks once chain lock is released.
 func (chain *Blockchain) AddBlock(block *Block) {
+	chain.mu.Lock()
+	var update ChainUpdate
 	err := chain.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(chain.config.GetDbBucket()))
 		blockInDb := b.Get(block.Hash)
@@ -135,24 +239,132 @@ func (chain *Blockchain) AddBlock(block *Block) {
 		blockData := block.Serialize()
 		err := b.Put(block.Hash, blockData)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
 		lastHash := b.Get([]byte("1"))
 		lastBlockData := b.Get(lastHash)
 		lastBlock := Deserialize(lastBlockData)
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("1"), block.Hash)
+		fork := findFork(b, lastBlock, block)
+		if chain.weight(fork.Connected) <= chain.weight(fork.Disconnected) {
+			return nil
+		}
+		if chain.AssumeValid && chain.containsAssumeValid(fork.Disconnected) {
+			fmt.Printf("block %x forks chain below assume valid block\n", block.Hash)
+			return nil
+		}
+		if !chain.verifiedFork(fork.Connected) {
+			fmt.Printf("block %x waits for assume valid block before joining chain\n", block.Hash)
+			return nil
+		}
+		if chain.containsAssumeValid(fork.Connected) {
+			chain.unverified = make(map[string]bool)
+		}
+		update = fork
+		store := UtxoStore{chain}
+		if len(update.Disconnected) == 0 {
+			for _, connected := range update.Connected {
+				err = store.update(tx, connected)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			fmt.Printf("reorganizing chain [disconnected:%d] [connected:%d]\n", len(update.Disconnected), len(update.Connected))
+			err = store.reindex(tx, block.Hash)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
-			chain.tip = block.Hash
 		}
+		err = b.Put([]byte("1"), block.Hash)
+		if err != nil {
+			return err
+		}
+		chain.tip = block.Hash
+		chain.bestHeight = block.Height
 		return nil
 	})
+	subscribers := chain.subscribers
+	chain.mu.Unlock()
 	if err != nil {
 		log.Panic(err)
 	}
+	if len(update.Connected) > 0 {
+		for _, notify := range subscribers {
+			notify(update)
+		}
+	}
+}
+
+// verifiedFork checks that blocks with unchecked signatures join main chain only together with
+// assume valid block, which proves they are its ancestors
+func (chain *Blockchain) verifiedFork(connected []*Block) bool {
+	if chain.containsAssumeValid(connected) {
+		return true
+	}
+	for _, block := range connected {
+		if chain.unverified[hex.EncodeToString(block.Hash)] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAssumeValid checks if blocks include assume valid block of network
+func (chain *Blockchain) containsAssumeValid(blocks []*Block) bool {
+	for _, block := range blocks {
+		if hex.EncodeToString(block.Hash) == chain.Params.AssumeValid.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+// weight sums fork choice weight of blocks
+func (chain *Blockchain) weight(blocks []*Block) int {
+	weight := 0
+	for _, block := range blocks {
+		weight += chain.Engine.Weight(block)
+	}
+	return weight
+}
+
+// Subscribe registers function called with every change of main chain
+func (chain *Blockchain) Subscribe(notify func(ChainUpdate)) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	chain.subscribers = append(chain.subscribers, notify)
+}
+
+// findFork walks back from old and new tip to their common ancestor.
+// Chains with different genesis blocks are walked to the end.
+func findFork(blocks *bolt.Bucket, oldTip, newTip *Block) ChainUpdate {
+	var update ChainUpdate
+	parent := func(block *Block) *Block {
+		data := blocks.Get(block.PrevBlockHash)
+		if len(block.PrevBlockHash) == 0 || data == nil {
+			return nil
+		}
+		return Deserialize(data)
+	}
+	for oldTip != nil || newTip != nil {
+		if oldTip != nil && newTip != nil && bytes.Equal(oldTip.Hash, newTip.Hash) {
+			break
+		}
+		if newTip == nil || (oldTip != nil && oldTip.Height >= newTip.Height) {
+			update.Disconnected = append(update.Disconnected, oldTip)
+			oldTip = parent(oldTip)
+		} else {
+			update.Connected = append([]*Block{newTip}, update.Connected...)
+			newTip = parent(newTip)
+		}
+	}
+	return update
+}
+
+// Close closes chain database
+func (chain *Blockchain) Close() error {
+	return chain.db.Close()
 }
 
 // GetBlock finds a block by its hash and returns it
@@ -173,9 +385,47 @@ func (chain *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
+// HasBlock checks if block with given hash is stored
+func (chain *Blockchain) HasBlock(blockHash []byte) bool {
+	found := false
+	err := chain.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(chain.config.GetDbBucket())).Get(blockHash) != nil
+		return nil
+	})
+	return err == nil && found
+}
+
+// LastCheckpoint gets height of the highest checkpoint in chain, -1 when chain has none
+func (chain *Blockchain) LastCheckpoint() int {
+	height := -1
+	for _, checkpoint := range chain.Params.Checkpoints {
+		hash, err := hex.DecodeString(checkpoint.Hash)
+		if err == nil && checkpoint.Height > height && chain.HasBlock(hash) {
+			height = checkpoint.Height
+		}
+	}
+	return height
+}
+
+// IsInitialBlockDownload checks if chain is still catching up with network: its tip is older than MaxTipAge
+func (chain *Blockchain) IsInitialBlockDownload() bool {
+	tip, _ := chain.Tip()
+	block, err := chain.GetBlock(tip)
+	if err != nil {
+		return true
+	}
+	return time.Unix(block.Timestamp, 0).Before(chain.Time.Now().Add(-MaxTipAge))
+}
+
 // Iterator makes new Blockchain iterator
 func (chain *Blockchain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{chain.tip, chain.db, chain.config}
+	tip, _ := chain.Tip()
+	return chain.IteratorFrom(tip)
+}
+
+// IteratorFrom makes new Blockchain iterator starting at block with given hash
+func (chain *Blockchain) IteratorFrom(hash []byte) *BlockchainIterator {
+	return &BlockchainIterator{hash, chain.db, chain.config}
 }
 
 // Log logs current blockchain
@@ -207,25 +457,41 @@ func (it *BlockchainIterator) Next() *Block {
 	return block
 }
 
-// FindUtxo gets unspent transactions outputs
-func (chain *Blockchain) FindUtxo() map[string][]TxOutput {
-	unspent := make(map[string][]TxOutput)
+// FindUtxo gets unspent transactions outputs of the main chain
+func (chain *Blockchain) FindUtxo() map[string]TxOutputs {
+	var unspent map[string]TxOutputs
+	err := chain.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(chain.config.GetDbBucket()))
+		unspent = findUtxo(b, b.Get([]byte("1")))
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return unspent
+}
+
+// findUtxo collects unspent outputs by walking blocks bucket from tip to genesis,
+// transactions of block are walked backwards as they may spend outputs of earlier ones
+func findUtxo(blocks *bolt.Bucket, tip []byte) map[string]TxOutputs {
+	unspent := make(map[string]TxOutputs)
 	spent := make(map[string][]int)
-	it := chain.Iterator()
-	for {
-		block := it.Next()
-		for _, tx := range block.Transactions {
+	for hash := tip; len(hash) > 0; {
+		block := Deserialize(blocks.Get(hash))
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
 			txID := hex.EncodeToString(tx.ID)
 		Out:
 			for outI, out := range tx.Vout {
-				if spent[txID] != nil {
-					for _, spentOut := range spent[txID] {
-						if spentOut == outI {
-							continue Out
-						}
+				for _, spentOut := range spent[txID] {
+					if spentOut == outI {
+						continue Out
 					}
 				}
-				unspent[txID] = append(unspent[txID], out)
+				if unspent[txID] == nil {
+					unspent[txID] = make(TxOutputs)
+				}
+				unspent[txID][outI] = out
 			}
 			if tx.IsCoinbase() == false {
 				for _, in := range tx.Vin {
@@ -234,9 +500,7 @@ func (chain *Blockchain) FindUtxo() map[string][]TxOutput {
 				}
 			}
 		}
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+		hash = block.PrevBlockHash
 	}
 	return unspent
 }
@@ -245,8 +509,7 @@ func (chain *Blockchain) FindUtxo() map[string][]TxOutput {
 func (chain *Blockchain) GetBalance(address string) int {
 	var balance int
 	store := &UtxoStore{chain}
-	pubKeyHash, _ := base58.Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-AddressChecksumLength]
+	pubKeyHash, _ := chain.Params.DecodeAddress(address)
 	for _, utxo := range store.FindUtxo(pubKeyHash) {
 		balance += utxo.Value
 	}
@@ -255,20 +518,36 @@ func (chain *Blockchain) GetBalance(address string) int {
 
 // NewTransaction generates new transaction from spendable outputs
 func (chain *Blockchain) NewTransaction(from, to string, amount int) (*Transaction, error) {
+	return chain.NewTransactionWithFee(from, to, amount, 0, false)
+}
+
+// NewTransactionWithFee generates new transaction from spendable outputs leaving fee to miner.
+// Replaceable transaction signals in its inputs that it may be replaced by one paying higher fee.
+func (chain *Blockchain) NewTransactionWithFee(from, to string, amount, fee int, replaceable bool) (*Transaction, error) {
 	var txins []TxInput
 	var txous []TxOutput
 	store := &UtxoStore{chain}
-	pubKeyHash, _ := PubKeyHash(from)
-	spendable, outs := store.FindSpendableOutputs(pubKeyHash, amount)
-	if spendable < amount {
+	pubKeyHash, err := chain.Params.DecodeAddress(from)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = chain.Params.DecodeAddress(to); err != nil {
+		return nil, err
+	}
+	spendable, outs := store.FindSpendableOutputs(pubKeyHash, amount+fee)
+	if spendable < amount+fee {
 		return nil, fmt.Errorf("not enough balance")
 	}
 	wFrom := chain.ws.GetWallet(from)
 	if wFrom == nil {
 		fmt.Printf("no such wallet")
-		return nil, fmt.Errorf("no such wallet %s", wFrom)
+		return nil, fmt.Errorf("no such wallet %s", from)
+	}
+	sequence := uint32(SequenceFinal)
+	if replaceable {
+		sequence = MaxRBFSequence
 	}
-	returnable := spendable - amount
+	returnable := spendable - amount - fee
 	for txi, touts := range outs {
 		txid, err := hex.DecodeString(txi)
 		if err != nil {
@@ -276,20 +555,29 @@ func (chain *Blockchain) NewTransaction(from, to string, amount int) (*Transacti
 			return nil, error(err)
 		}
 		for _, out := range touts {
-			txins = append(txins, TxInput{txid, out, nil, wFrom.PublicKey})
+			txins = append(txins, TxInput{txid, out, nil, wFrom.PublicKey, sequence})
 		}
 	}
 	txous = append(txous, *NewTxOutput(amount, to))
 	txous = append(txous, *NewTxOutput(returnable, from))
 	tx := &Transaction{nil, txins, txous}
 	tx.ID = tx.Hash()
-	fmt.Printf("produced transaction [id:%x] [from:%s] [to:%s] [amount:%d]\n", tx.ID, from, to, amount)
+	fmt.Printf("produced transaction [id:%x] [from:%s] [to:%s] [amount:%d] [fee:%d]\n", tx.ID, from, to, amount, fee)
 	return tx, nil
 }
 
 // GetTransaction gets transaction by id
 func (chain *Blockchain) GetTransaction(id []byte) (Transaction, error) {
-	it := chain.Iterator()
+	tip, _ := chain.Tip()
+	return chain.getTransactionFrom(tip, id)
+}
+
+// getTransactionFrom finds transaction by id in block with given hash and its ancestors
+func (chain *Blockchain) getTransactionFrom(hash []byte, id []byte) (Transaction, error) {
+	if len(hash) == 0 {
+		return Transaction{}, errors.New("transaction not found")
+	}
+	it := chain.IteratorFrom(hash)
 	for {
 		block := it.Next()
 		for _, tx := range block.Transactions {
@@ -310,12 +598,22 @@ func (chain *Blockchain) SendFromAddress(pk ecdsa.PrivateKey, from string, to st
 	fmt.Printf("signing transactions\n")
 	tx.Log()
 	chain.SignTransaction(&pk, tx)
-	block, _ := chain.MineBlock([]*Transaction{tx})
-	store := &UtxoStore{chain}
-	return store.Update(block)
+	_, err := chain.MineBlock([]*Transaction{tx})
+	return err
 }
 
-// GetBlockHashes returns a list of all blocks hashes
+// Genesis gets first block of chain
+func (chain *Blockchain) Genesis() *Block {
+	bci := chain.Iterator()
+	for {
+		block := bci.Next()
+		if len(block.PrevBlockHash) == 0 {
+			return block
+		}
+	}
+}
+
+// GetBlockHashes returns a list of all blocks hashes, starting from genesis
 func (chain *Blockchain) GetBlockHashes() [][]byte {
 	var blocks [][]byte
 	bci := chain.Iterator()
@@ -326,10 +624,10 @@ func (chain *Blockchain) GetBlockHashes() [][]byte {
 			break
 		}
 	}
-	// last := len(blocks) - 1
-	// for i := 0; i < len(blocks)/2; i++ {
-	// 	blocks[i], blocks[last-i] = blocks[last-i], blocks[i]
-	// }
+	last := len(blocks) - 1
+	for i := 0; i < len(blocks)/2; i++ {
+		blocks[i], blocks[last-i] = blocks[last-i], blocks[i]
+	}
 	return blocks
 }
 
@@ -340,10 +638,38 @@ func (chain *Blockchain) Send(wallet *Wallet, to string, amount int) error {
 
 // GetPreviousTransactions gets previous transactions
 func (chain *Blockchain) GetPreviousTransactions(tx Transaction) map[string]Transaction {
+	tip, _ := chain.Tip()
+	return chain.getPreviousTransactionsFrom(tip, tx)
+}
+
+// getPreviousTransactionsFrom gets previous transactions from block with given hash and its ancestors
+func (chain *Blockchain) getPreviousTransactionsFrom(hash []byte, tx Transaction) map[string]Transaction {
 	ptxs := make(map[string]Transaction)
 	for _, vin := range tx.Vin {
-		ptx, _ := chain.GetTransaction(vin.Txid)
+		ptx, _ := chain.getTransactionFrom(hash, vin.Txid)
+		txid := hex.EncodeToString(vin.Txid)
+		ptxs[txid] = ptx
+	}
+	return ptxs
+}
+
+// getBlockPreviousTransactions gets previous transactions of i-th transaction of block on top of given hash,
+// outputs of earlier transactions in the same block may be spent
+func (chain *Blockchain) getBlockPreviousTransactions(hash []byte, txs []*Transaction, i int) map[string]Transaction {
+	ptxs := make(map[string]Transaction)
+	for _, vin := range txs[i].Vin {
 		txid := hex.EncodeToString(vin.Txid)
+		if _, ok := ptxs[txid]; ok {
+			continue
+		}
+		ptx, err := chain.getTransactionFrom(hash, vin.Txid)
+		if err != nil {
+			for _, t := range txs[:i] {
+				if bytes.Equal(t.ID, vin.Txid) {
+					ptx = *t
+				}
+			}
+		}
 		ptxs[txid] = ptx
 	}
 	return ptxs
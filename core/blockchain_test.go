@@ -30,7 +30,8 @@ func newData(removeFile bool) *data {
 	a1 = string(w1.GetAddress())
 	w2 = wstore.CreateWallet()
 	a2 = string(w2.GetAddress())
-	chain := InitChain(env, a1, nodeID)
+	chain := InitChain(env, nodeID)
+	chain.MineBlock([]*Transaction{NewCoinbaseTransaction(a1, "fund wallet", chain.Params.BlockSubsidy(1))})
 	return &data{wstore, w1, w2, a1, a2, chain}
 }
 
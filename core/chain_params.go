@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+// Networks selected by NETWORK
+const (
+	NetworkMain      = "main"
+	NetworkTest      = "test"
+	NetworkRegtest   = "regtest"
+	NetworkAuthority = "authority"
+)
+
+// genesisPubKeyHash is paid by genesis coinbase of all networks, nobody holds its key so genesis reward is unspendable
+var genesisPubKeyHash = make([]byte, 20)
+
+// ChainParams defines network: its messages, addresses, default P2P and RPC ports, seed peers, genesis block and consensus rules
+type ChainParams struct {
+	Name                 string
+	Magic                uint32
+	DefaultPort          string
+	DefaultRPCPort       string
+	AddressVersion       byte
+	SeedPeers            []string
+	GenesisData          string
+	GenesisTimestamp     int64
+	GenesisNonce         int
+	GenesisOutput        TxOutput
+	GenesisHash          string
+	Subsidy              int
+	HalvingInterval      int
+	Difficulty           int
+	Consensus            string
+	Authorities          []string
+	MaxFutureBlockTime   time.Duration
+	MaxBlockSize         int
+	MaxTxSize            int
+	MaxBlockTransactions int
+	Checkpoints          []Checkpoint
+	AssumeValid          Checkpoint
+}
+
+// Checkpoint is hash of block at height, chains without the block are rejected
+type Checkpoint struct {
+	Height int
+	Hash   string
+}
+
+// MainParams are parameters of main network
+var MainParams = ChainParams{
+	Name:                 NetworkMain,
+	Magic:                NetworkMagic,
+	DefaultPort:          "3000",
+	DefaultRPCPort:       "4000",
+	AddressVersion:       0x01,
+	GenesisData:          "genesis coinbase data",
+	GenesisTimestamp:     1704067200,
+	GenesisNonce:         4232,
+	GenesisOutput:        TxOutput{50, genesisPubKeyHash},
+	GenesisHash:          "000aa23a832d790e334698f7ada10739e7925f691fd8bc621301dfadbba53b47",
+	Subsidy:              50,
+	HalvingInterval:      210000,
+	Difficulty:           Difficulty,
+	Consensus:            ConsensusPoW,
+	MaxFutureBlockTime:   2 * time.Hour,
+	MaxBlockSize:         1 << 20,
+	MaxTxSize:            100000,
+	MaxBlockTransactions: 5000,
+	Checkpoints:          []Checkpoint{{0, "000aa23a832d790e334698f7ada10739e7925f691fd8bc621301dfadbba53b47"}},
+}
+
+// TestParams are parameters of public test network
+var TestParams = ChainParams{
+	Name:                 NetworkTest,
+	Magic:                0x0709110b,
+	DefaultPort:          "13000",
+	DefaultRPCPort:       "14000",
+	AddressVersion:       0x6f,
+	GenesisData:          "test network genesis coinbase data",
+	GenesisTimestamp:     1704067200,
+	GenesisNonce:         2658,
+	GenesisOutput:        TxOutput{50, genesisPubKeyHash},
+	GenesisHash:          "0008270b1cfcf940ab64686d91ad33893a14f60704b487888df4c73d251560cd",
+	Subsidy:              50,
+	HalvingInterval:      210000,
+	Difficulty:           Difficulty,
+	Consensus:            ConsensusPoW,
+	MaxFutureBlockTime:   2 * time.Hour,
+	MaxBlockSize:         1 << 20,
+	MaxTxSize:            100000,
+	MaxBlockTransactions: 5000,
+	Checkpoints:          []Checkpoint{{0, "0008270b1cfcf940ab64686d91ad33893a14f60704b487888df4c73d251560cd"}},
+}
+
+// RegtestParams are parameters of local regression test network with trivial proof of work
+var RegtestParams = ChainParams{
+	Name:                 NetworkRegtest,
+	Magic:                0xdab5bffa,
+	DefaultPort:          "23000",
+	DefaultRPCPort:       "24000",
+	AddressVersion:       0x70,
+	GenesisData:          "regtest genesis coinbase data",
+	GenesisTimestamp:     1704067200,
+	GenesisNonce:         0,
+	GenesisOutput:        TxOutput{50, genesisPubKeyHash},
+	GenesisHash:          "84c95d844665476db5faa9139a372ade06583577dfc81df0e96e5fa3731439eb",
+	Subsidy:              50,
+	HalvingInterval:      150,
+	Difficulty:           RegtestDifficulty,
+	Consensus:            ConsensusPoW,
+	MaxFutureBlockTime:   2 * time.Hour,
+	MaxBlockSize:         1 << 20,
+	MaxTxSize:            100000,
+	MaxBlockTransactions: 5000,
+	Checkpoints:          []Checkpoint{{0, "84c95d844665476db5faa9139a372ade06583577dfc81df0e96e5fa3731439eb"}},
+}
+
+// AuthorityParams are parameters of permissioned network where blocks are signed by authorities in turn,
+// authorities are configured with AUTHORITIES
+var AuthorityParams = ChainParams{
+	Name:                 NetworkAuthority,
+	Magic:                0x0a17c0de,
+	DefaultPort:          "33000",
+	DefaultRPCPort:       "34000",
+	AddressVersion:       0x17,
+	GenesisData:          "authority genesis coinbase data",
+	GenesisTimestamp:     1704067200,
+	GenesisNonce:         0,
+	GenesisOutput:        TxOutput{50, genesisPubKeyHash},
+	GenesisHash:          "53e8591c5047a946c9e39cf7c3f0e0a4f8b0c96b0bc4fbe2eab63287129c5bf5",
+	Subsidy:              50,
+	HalvingInterval:      210000,
+	Difficulty:           0,
+	Consensus:            ConsensusPoA,
+	MaxFutureBlockTime:   2 * time.Hour,
+	MaxBlockSize:         1 << 20,
+	MaxTxSize:            100000,
+	MaxBlockTransactions: 5000,
+	Checkpoints:          []Checkpoint{{0, "53e8591c5047a946c9e39cf7c3f0e0a4f8b0c96b0bc4fbe2eab63287129c5bf5"}},
+}
+
+// networks lists parameters of all networks
+var networks = []*ChainParams{&MainParams, &TestParams, &RegtestParams, &AuthorityParams}
+
+// ParamsForNetwork gets parameters of network by name
+func ParamsForNetwork(network string) (*ChainParams, error) {
+	for _, params := range networks {
+		if params.Name == network {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network %s", network)
+}
+
+// GenesisBlock builds genesis block of network, every node of the network builds the same block
+func (params *ChainParams) GenesisBlock() *Block {
+	txin := TxInput{[]byte{}, -1, nil, []byte(params.GenesisData), SequenceFinal}
+	coinbase := &Transaction{[]byte{}, []TxInput{txin}, []TxOutput{params.GenesisOutput}}
+	coinbase.ID = coinbase.Hash()
+	block := &Block{params.GenesisTimestamp, []*Transaction{coinbase}, []byte{}, []byte{}, params.GenesisNonce, 0, params.Difficulty, nil, nil}
+	block.Hash = block.computeHash()
+	return block
+}
+
+// IsGenesis checks if block is genesis block of network
+func (params *ChainParams) IsGenesis(hash []byte) bool {
+	return hex.EncodeToString(hash) == params.GenesisHash
+}
+
+// Checkpoint gets checkpoint at height
+func (params *ChainParams) Checkpoint(height int) (Checkpoint, bool) {
+	for _, checkpoint := range params.Checkpoints {
+		if checkpoint.Height == height {
+			return checkpoint, true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+// ParseCheckpoint parses checkpoint written as height:hash
+func ParseCheckpoint(value string) (Checkpoint, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), ":", 2)
+	if len(parts) != 2 {
+		return Checkpoint{}, fmt.Errorf("checkpoint %s is not height:hash", value)
+	}
+	height, err := strconv.Atoi(parts[0])
+	if err != nil || height < 0 {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint height %s", parts[0])
+	}
+	hash, err := hex.DecodeString(parts[1])
+	if err != nil || len(hash) != sha256.Size {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint hash %s", parts[1])
+	}
+	return Checkpoint{height, hex.EncodeToString(hash)}, nil
+}
+
+// WithCheckpoints copies parameters adding checkpoints and replacing assume valid block when it is set
+func (params *ChainParams) WithCheckpoints(checkpoints []Checkpoint, assumeValid Checkpoint) *ChainParams {
+	configured := *params
+	configured.Checkpoints = append(append([]Checkpoint{}, params.Checkpoints...), checkpoints...)
+	if assumeValid.Hash != "" {
+		configured.AssumeValid = assumeValid
+	}
+	return &configured
+}
+
+// BlockSubsidy gets block reward at height, it halves every halving interval
+func (params *ChainParams) BlockSubsidy(height int) int {
+	halvings := height / params.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return params.Subsidy >> uint(halvings)
+}
+
+// EncodeAddress makes network address from public key hash
+func (params *ChainParams) EncodeAddress(pubKeyHash []byte) string {
+	versioned := append([]byte{params.AddressVersion}, pubKeyHash...)
+	checksum := ShaChecksum(versioned, AddressChecksumLength)
+	return base58.Encode(append(versioned, checksum...))
+}
+
+// DecodeAddress gets public key hash from address, addresses of other networks are rejected
+func (params *ChainParams) DecodeAddress(address string) ([]byte, error) {
+	decoded, err := base58.Decode(address)
+	if err != nil || len(decoded) <= 1+AddressChecksumLength {
+		return nil, fmt.Errorf("invalid address %s", address)
+	}
+	payload := decoded[:len(decoded)-AddressChecksumLength]
+	if !bytes.Equal(decoded[len(payload):], ShaChecksum(payload, AddressChecksumLength)) {
+		return nil, fmt.Errorf("invalid address checksum %s", address)
+	}
+	if payload[0] != params.AddressVersion {
+		return nil, fmt.Errorf("address %s does not belong to %s network", address, params.Name)
+	}
+	return payload[1:], nil
+}
+
+// PeerAddress adds default port of network to peer address without port
+func (params *ChainParams) PeerAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, params.DefaultPort)
+	}
+	return address
+}
+
+// RPCPort gets JSON-RPC port of node listening for peers on port nodeID. RPC port is moved from DefaultRPCPort
+// by the same offset as node port from DefaultPort, so nodes on one host get distinct RPC ports.
+// DefaultRPCPort is used when nodeID is not a port or the moved port is out of range.
+func (params *ChainParams) RPCPort(nodeID string) string {
+	port, err := strconv.Atoi(nodeID)
+	if err != nil {
+		return params.DefaultRPCPort
+	}
+	defaultPort, _ := strconv.Atoi(params.DefaultPort)
+	defaultRPCPort, _ := strconv.Atoi(params.DefaultRPCPort)
+	rpcPort := defaultRPCPort + port - defaultPort
+	if rpcPort <= 0 || rpcPort > 65535 {
+		return params.DefaultRPCPort
+	}
+	return strconv.Itoa(rpcPort)
+}
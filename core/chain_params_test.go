@@ -0,0 +1,119 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainParamsAddresses(t *testing.T) {
+	wallet := NewWallet()
+	pubKeyHash := RipeMd160Sha256(wallet.PublicKey)
+	mainAddress := string(wallet.GetAddress())
+	decoded, err := MainParams.DecodeAddress(mainAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, decoded)
+	_, err = TestParams.DecodeAddress(mainAddress)
+	assert.NotNil(t, err)
+	_, err = MainParams.DecodeAddress(mainAddress[:len(mainAddress)-1] + "1")
+	assert.NotNil(t, err)
+	_, err = MainParams.DecodeAddress("")
+	assert.NotNil(t, err)
+
+	testWallet := NewNetworkWallet(&TestParams)
+	testAddress := string(testWallet.GetAddress())
+	assert.True(t, testWallet.IsValidAddress())
+	_, err = MainParams.DecodeAddress(testAddress)
+	assert.NotNil(t, err)
+	testWallet.PublicKey = wallet.PublicKey
+	assert.NotEqual(t, mainAddress, string(testWallet.GetAddress()))
+	decoded, err = PubKeyHash(string(testWallet.GetAddress()))
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, decoded)
+	assert.Equal(t, pubKeyHash, NewTxOutput(1, mainAddress).PubKeyHash)
+	_, err = PubKeyHash(mainAddress[:len(mainAddress)-1] + "1")
+	assert.NotNil(t, err)
+}
+
+func TestChainParamsSelect(t *testing.T) {
+	_, err := ParamsForNetwork("unknown")
+	assert.NotNil(t, err)
+	params, err := ParamsForNetwork(NetworkRegtest)
+	assert.Nil(t, err)
+	assert.Equal(t, RegtestDifficulty, params.Difficulty)
+	assert.Equal(t, "localhost:23000", params.PeerAddress("localhost"))
+	assert.Equal(t, "localhost:3001", params.PeerAddress("localhost:3001"))
+	t.Setenv("NETWORK", NetworkRegtest)
+	t.Setenv("SEED_PEERS", "")
+	assert.Empty(t, (&EnvConfig{}).GetSeedPeers())
+	t.Setenv("SEED_PEERS", "localhost")
+	assert.Equal(t, []string{"localhost:23000"}, (&EnvConfig{}).GetSeedPeers())
+}
+
+func TestChainParamsRPCPort(t *testing.T) {
+	ports := map[string]bool{}
+	for _, params := range []*ChainParams{&MainParams, &TestParams, &RegtestParams, &AuthorityParams} {
+		ports[params.DefaultPort] = true
+	}
+	for _, params := range []*ChainParams{&MainParams, &TestParams, &RegtestParams, &AuthorityParams} {
+		assert.Equal(t, params.DefaultRPCPort, params.RPCPort(params.DefaultPort), params.Name)
+		assert.False(t, ports[params.DefaultRPCPort], params.Name)
+		ports[params.DefaultRPCPort] = true
+	}
+	assert.Equal(t, "4001", MainParams.RPCPort("3001"))
+	assert.Equal(t, "14001", TestParams.RPCPort("13001"))
+	assert.Equal(t, "34000", AuthorityParams.RPCPort("node"))
+	assert.Equal(t, "24000", RegtestParams.RPCPort("65000"))
+
+	t.Setenv("NETWORK", NetworkAuthority)
+	config := &EnvConfig{}
+	assert.Equal(t, "localhost:34001", config.GetRPCAddress("33001"))
+	t.Setenv("RPC_ADDRESS", "127.0.0.1:9000")
+	assert.Equal(t, "127.0.0.1:9000", config.GetRPCAddress("33001"))
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	assert.Equal(t, 50, RegtestParams.BlockSubsidy(0))
+	assert.Equal(t, 50, RegtestParams.BlockSubsidy(149))
+	assert.Equal(t, 25, RegtestParams.BlockSubsidy(150))
+	assert.Equal(t, 12, RegtestParams.BlockSubsidy(300))
+	assert.Equal(t, 0, RegtestParams.BlockSubsidy(150*64))
+}
+
+func TestGenesisBlock(t *testing.T) {
+	for _, params := range []*ChainParams{&MainParams, &TestParams, &RegtestParams} {
+		genesis := params.GenesisBlock()
+		assert.True(t, params.IsGenesis(genesis.Hash), params.Name)
+		assert.True(t, genesis.ValidatePOW(), params.Name)
+		assert.Equal(t, genesis.Hash, params.GenesisBlock().Hash)
+		assert.Equal(t, 0, genesis.Height)
+		assert.True(t, genesis.Transactions[0].IsCoinbase())
+	}
+	assert.False(t, TestParams.IsGenesis(MainParams.GenesisBlock().Hash))
+}
+
+func TestInitChainSharesGenesis(t *testing.T) {
+	dir := t.TempDir()
+	a := InitChain(&testConfig{dir: dir}, "a")
+	b := InitChain(&testConfig{dir: dir}, "b")
+	assert.Equal(t, a.Genesis().Hash, b.Genesis().Hash)
+	assert.True(t, MainParams.IsGenesis(a.Genesis().Hash))
+	a.Close()
+	b.Close()
+
+	assert.Panics(t, func() { InitChain(&testConfig{dir: dir, network: NetworkRegtest}, "a") })
+}
+
+func TestEnvConfigNetworkFiles(t *testing.T) {
+	config := &EnvConfig{}
+	t.Setenv("BOLT_DB_FILE", "/tmp/gochain_%s")
+	t.Setenv("PEERS_FILE", "peers_%s.dat")
+	t.Setenv("NETWORK", NetworkMain)
+	assert.Equal(t, "/tmp/gochain_main_3000", config.GetDbFile("3000"))
+	files := []string{config.GetDbFile("3000"), config.GetWalletStoreFile("3000"), config.GetPeersFile("3000"), config.GetBansFile("3000"), config.GetMempoolFile("3000")}
+	t.Setenv("NETWORK", NetworkTest)
+	assert.Equal(t, "peers_test_3000.dat", config.GetPeersFile("3000"))
+	for i, file := range []string{config.GetDbFile("3000"), config.GetWalletStoreFile("3000"), config.GetPeersFile("3000"), config.GetBansFile("3000"), config.GetMempoolFile("3000")} {
+		assert.NotEqual(t, files[i], file)
+	}
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBlock seals block with transactions on top of parent
+func newTestBlock(t *testing.T, chain *Blockchain, parent *Block, timestamp int64, txs ...*Transaction) *Block {
+	block := &Block{timestamp, txs, parent.Hash, []byte{}, 0, parent.Height + 1, 0, nil, nil}
+	assert.Nil(t, chain.Engine.Prepare(chain, block))
+	assert.Nil(t, chain.Engine.Seal(context.Background(), block))
+	return block
+}
+
+func TestCheckpointRejectsForks(t *testing.T) {
+	chain := newTestChain(t)
+	address := string(NewWallet().GetAddress())
+	var blocks []*Block
+	for i := 1; i <= 3; i++ {
+		block, err := chain.MineBlock([]*Transaction{NewCoinbaseTransaction(address, fmt.Sprintf("%d", i), 50)})
+		assert.Nil(t, err)
+		blocks = append(blocks, block)
+	}
+	assert.Equal(t, 0, chain.LastCheckpoint())
+
+	params := *chain.Params
+	params.Checkpoints = append([]Checkpoint{}, params.Checkpoints...)
+	params.Checkpoints = append(params.Checkpoints, Checkpoint{2, hex.EncodeToString(blocks[1].Hash)})
+	chain.Params = &params
+	assert.Equal(t, 2, chain.LastCheckpoint())
+
+	atCheckpoint := newTestBlock(t, chain, blocks[0], blocks[1].Timestamp, NewCoinbaseTransaction(address, "fork 2", 50))
+	err := chain.ValidateBlock(atCheckpoint)
+	assert.True(t, isProtocolError(err))
+	assert.Contains(t, err.Error(), "does not match checkpoint")
+
+	genesis := chain.Genesis()
+	belowCheckpoint := newTestBlock(t, chain, genesis, blocks[0].Timestamp+1, NewCoinbaseTransaction(address, "fork 1", 50))
+	err = chain.ValidateBlock(belowCheckpoint)
+	assert.True(t, isProtocolError(err))
+	assert.Contains(t, err.Error(), "below checkpoint")
+
+	assert.Nil(t, chain.ValidateBlock(blocks[0]))
+	assert.Nil(t, chain.ValidateBlock(genesis))
+	aboveCheckpoint := newTestBlock(t, chain, blocks[1], blocks[2].Timestamp, NewCoinbaseTransaction(address, "fork 3", 50))
+	assert.Nil(t, chain.ValidateBlock(aboveCheckpoint))
+}
+
+func TestAssumeValidSkipsSignatures(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	genesis := chain.Genesis()
+	assert.True(t, chain.IsInitialBlockDownload())
+
+	cbTx := NewCoinbaseTransaction(address, "assumed", 50)
+	spend := newTestTx(wallet, cbTx, 0, *NewTxOutput(50, address))
+	spend.Vin[0].Signature = make([]byte, len(spend.Vin[0].Signature))
+	spend.ID = spend.Hash()
+	block := newTestBlock(t, chain, genesis, genesis.Timestamp+600, cbTx, spend)
+	assert.True(t, isProtocolError(chain.ValidateBlock(block)))
+
+	params := *chain.Params
+	params.AssumeValid = Checkpoint{5, "00aa"}
+	chain.Params = &params
+	assert.Nil(t, chain.ValidateBlock(block))
+
+	chain.AssumeValid = false
+	assert.True(t, isProtocolError(chain.ValidateBlock(block)))
+	chain.AssumeValid = true
+
+	params.AssumeValid = Checkpoint{1, "00aa"}
+	err := chain.ValidateBlock(block)
+	assert.True(t, isProtocolError(err))
+	assert.Contains(t, err.Error(), "assume valid")
+
+	params.AssumeValid = Checkpoint{5, "00aa"}
+	chain.AddBlock(newTestBlock(t, chain, genesis, chain.Time.Now().Unix(), NewCoinbaseTransaction(address, "recent", 50)))
+	assert.False(t, chain.IsInitialBlockDownload())
+	assert.True(t, isProtocolError(chain.ValidateBlock(block)))
+}
+
+func TestAssumeValidJoinsOnlyAncestors(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	genesis := chain.Genesis()
+
+	cbTx := NewCoinbaseTransaction(address, "assumed", 50)
+	spend := newTestTx(wallet, cbTx, 0, *NewTxOutput(50, address))
+	spend.Vin[0].Signature = make([]byte, len(spend.Vin[0].Signature))
+	spend.ID = spend.Hash()
+	first := newTestBlock(t, chain, genesis, genesis.Timestamp+600, cbTx, spend)
+	second := newTestBlock(t, chain, first, genesis.Timestamp+1200, NewCoinbaseTransaction(address, "second", 50))
+	assumed := newTestBlock(t, chain, second, genesis.Timestamp+1800, NewCoinbaseTransaction(address, "assumed block", 50))
+	fork := newTestBlock(t, chain, first, genesis.Timestamp+1200, NewCoinbaseTransaction(address, "fork", 50))
+
+	params := *chain.Params
+	params.AssumeValid = Checkpoint{3, hex.EncodeToString(assumed.Hash)}
+	chain.Params = &params
+	assert.Nil(t, chain.ValidateBlock(first))
+	chain.AddBlock(first)
+	tip, height := chain.Tip()
+	assert.Equal(t, genesis.Hash, tip)
+	assert.Equal(t, 0, height)
+	assert.True(t, chain.HasBlock(first.Hash))
+
+	chain.AssumeValid = false
+	assert.Nil(t, chain.ValidateBlock(fork))
+	chain.AddBlock(fork)
+	tip, _ = chain.Tip()
+	assert.Equal(t, genesis.Hash, tip)
+	chain.AssumeValid = true
+
+	for _, block := range []*Block{second, assumed} {
+		assert.Nil(t, chain.ValidateBlock(block))
+		chain.AddBlock(block)
+	}
+	tip, height = chain.Tip()
+	assert.Equal(t, assumed.Hash, tip)
+	assert.Equal(t, 3, height)
+	assert.Empty(t, chain.unverified)
+}
+
+func TestConfiguredCheckpoints(t *testing.T) {
+	chain := newTestChain(t)
+	address := string(NewWallet().GetAddress())
+	block, err := chain.MineBlock([]*Transaction{NewCoinbaseTransaction(address, "checkpoint", 50)})
+	assert.Nil(t, err)
+	hash := hex.EncodeToString(block.Hash)
+
+	_, err = ParseCheckpoint("1")
+	assert.NotNil(t, err)
+	_, err = ParseCheckpoint("x:" + hash)
+	assert.NotNil(t, err)
+	_, err = ParseCheckpoint("1:00aa")
+	assert.NotNil(t, err)
+	t.Setenv("CHECKPOINTS", "1:"+strings.ToUpper(hash)+",bad")
+	t.Setenv("ASSUME_VALID", "1:"+hash)
+	config := &testConfig{dir: t.TempDir()}
+	assert.Equal(t, []Checkpoint{{1, hash}}, config.GetCheckpoints())
+	assert.Equal(t, Checkpoint{1, hash}, config.GetAssumeValid())
+
+	configured := InitChain(config, "configured")
+	defer configured.Close()
+	assert.Equal(t, append(MainParams.Checkpoints, Checkpoint{1, hash}), configured.Params.Checkpoints)
+	assert.Equal(t, Checkpoint{1, hash}, configured.Params.AssumeValid)
+	assert.Len(t, MainParams.Checkpoints, 1)
+	assert.Empty(t, MainParams.AssumeValid.Hash)
+	assert.Nil(t, configured.ValidateBlock(block))
+	configured.AddBlock(block)
+	assert.Equal(t, 1, configured.LastCheckpoint())
+}
+
+func TestAssumeValidPrunesUnverified(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	genesis := chain.Genesis()
+
+	unsigned := func(data string) (*Transaction, *Transaction) {
+		cbTx := NewCoinbaseTransaction(address, data, 50)
+		spend := newTestTx(wallet, cbTx, 0, *NewTxOutput(50, address))
+		spend.Vin[0].Signature = make([]byte, len(spend.Vin[0].Signature))
+		spend.ID = spend.Hash()
+		return cbTx, spend
+	}
+	first := newTestBlock(t, chain, genesis, genesis.Timestamp+600, NewCoinbaseTransaction(address, "first", 50))
+	second := newTestBlock(t, chain, first, genesis.Timestamp+1200, NewCoinbaseTransaction(address, "second", 50))
+	cbTx, spend := unsigned("side")
+	side := newTestBlock(t, chain, genesis, genesis.Timestamp+600, cbTx, spend)
+
+	params := *chain.Params
+	params.AssumeValid = Checkpoint{2, hex.EncodeToString(second.Hash)}
+	chain.Params = &params
+	for _, block := range []*Block{first, side} {
+		assert.Nil(t, chain.ValidateBlock(block))
+		chain.AddBlock(block)
+	}
+	assert.True(t, chain.unverified[hex.EncodeToString(side.Hash)])
+	assert.Nil(t, chain.ValidateBlock(second))
+	chain.AddBlock(second)
+	tip, _ := chain.Tip()
+	assert.Equal(t, second.Hash, tip)
+	assert.Empty(t, chain.unverified)
+
+	cbTx, spend = unsigned("late side")
+	lateSide := newTestBlock(t, chain, genesis, genesis.Timestamp+601, cbTx, spend)
+	assert.True(t, isProtocolError(chain.ValidateBlock(lateSide)))
+
+	sideSecond := newTestBlock(t, chain, side, side.Timestamp+600, NewCoinbaseTransaction(address, "side second", 50))
+	assert.True(t, isProtocolError(chain.ValidateBlock(sideSecond)))
+	chain.AddBlock(sideSecond)
+	chain.AddBlock(newTestBlock(t, chain, sideSecond, sideSecond.Timestamp+600, NewCoinbaseTransaction(address, "side third", 50)))
+	tip, _ = chain.Tip()
+	assert.Equal(t, second.Hash, tip)
+}
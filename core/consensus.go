@@ -0,0 +1,37 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Consensus engines selected by chain parameters
+const (
+	ConsensusPoW = "pow"
+	ConsensusPoA = "poa"
+)
+
+// ConsensusEngine decides who may produce blocks, how they are sealed and which chain is preferred
+type ConsensusEngine interface {
+	// Prepare sets consensus fields of block header before block is sealed
+	Prepare(chain *Blockchain, block *Block) error
+	// Seal completes block so it passes VerifySeal, sealing stops with context error when ctx is cancelled
+	Seal(ctx context.Context, block *Block) error
+	// VerifySeal checks consensus fields and seal of block received from peer
+	VerifySeal(chain *Blockchain, block *Block) error
+	// Weight gets fork choice weight added by block, chain with the highest total weight is preferred
+	Weight(block *Block) int
+}
+
+// NewConsensusEngine creates consensus engine of network,
+// authorities are addresses allowed to seal blocks with proof of authority
+func NewConsensusEngine(params *ChainParams, authorities []string) (ConsensusEngine, error) {
+	switch params.Consensus {
+	case ConsensusPoW:
+		return NewProofOfWork(runtime.NumCPU()), nil
+	case ConsensusPoA:
+		return NewProofOfAuthority(params, authorities)
+	}
+	return nil, fmt.Errorf("unknown consensus %s of %s network", params.Consensus, params.Name)
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofOfAuthorityRoundRobin(t *testing.T) {
+	first, second, other := NewNetworkWallet(&AuthorityParams), NewNetworkWallet(&AuthorityParams), NewNetworkWallet(&AuthorityParams)
+	t.Setenv("AUTHORITIES", string(first.GetAddress())+","+string(second.GetAddress()))
+	chain := InitChain(&testConfig{dir: t.TempDir(), network: NetworkAuthority}, "poa")
+	defer chain.Close()
+	poa, ok := chain.Engine.(*ProofOfAuthority)
+	assert.True(t, ok)
+	coinbase := func(height int) []*Transaction {
+		return []*Transaction{NewCoinbaseTransaction(string(first.GetAddress()), "poa", chain.Params.BlockSubsidy(height))}
+	}
+
+	_, err := chain.MineBlock(coinbase(1))
+	assert.NotNil(t, err)
+	assert.NotNil(t, poa.Authorize(other))
+	assert.Nil(t, poa.Authorize(first))
+	_, err = chain.MineBlock(coinbase(1))
+	assert.Equal(t, errNotInTurn, err)
+
+	assert.Nil(t, poa.Authorize(second))
+	block, err := chain.MineBlock(coinbase(1))
+	assert.Nil(t, err)
+	assert.Equal(t, second.PublicKey, block.Signer)
+	assert.Nil(t, poa.VerifySeal(chain, block))
+	assert.Equal(t, 1, poa.Weight(block))
+	_, height := chain.Tip()
+	assert.Equal(t, 1, height)
+
+	assert.Nil(t, poa.Authorize(first))
+	block, err = chain.MineBlock(coinbase(2))
+	assert.Nil(t, err)
+	assert.Equal(t, first.PublicKey, block.Signer)
+
+	outOfTurn := &Block{block.Timestamp, block.Transactions, block.Hash, []byte{}, 0, block.Height + 1, 0, first.PublicKey, nil}
+	outOfTurn.Hash = outOfTurn.computeHash()
+	assert.Nil(t, poa.Seal(context.Background(), outOfTurn))
+	assert.True(t, isProtocolError(poa.VerifySeal(chain, outOfTurn)))
+
+	tampered := *block
+	tampered.Signature = append([]byte{}, block.Signature...)
+	tampered.Signature[0] ^= 0xff
+	assert.True(t, isProtocolError(poa.VerifySeal(chain, &tampered)))
+}
+
+func TestProofOfWorkEngine(t *testing.T) {
+	chain := newTestChain(t)
+	pow, ok := chain.Engine.(*ProofOfWork)
+	assert.True(t, ok)
+	block := &Block{1, []*Transaction{}, []byte("prev"), []byte{}, 0, 1, 0, nil, nil}
+	assert.Nil(t, pow.Prepare(chain, block))
+	assert.Equal(t, Difficulty, block.Difficulty)
+	assert.False(t, block.ValidatePOW())
+	assert.Equal(t, misbehavior(ScoreInvalidBlock, "block hash does not meet difficulty [hash:%x]", block.Hash), pow.VerifySeal(chain, block))
+	assert.Nil(t, pow.Seal(context.Background(), block))
+	assert.Nil(t, pow.VerifySeal(chain, block))
+	assert.True(t, pow.Hashes() > 0)
+	assert.Equal(t, 1<<12, pow.Weight(block))
+	block.Difficulty = RegtestDifficulty
+	assert.Equal(t, misbehavior(ScoreInvalidBlock, "block difficulty %d, required %d [hash:%x]", RegtestDifficulty, Difficulty, block.Hash),
+		pow.VerifySeal(chain, block))
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// canonicalEncoder writes canonical encoding covered by block and transaction hashes.
+// Integers are big endian, int and int64 take 8 bytes, uint32 takes 4 bytes,
+// byte slices and lists are prefixed with their length as uint32.
+// Unlike gob, encoded bytes depend only on encoded values.
+type canonicalEncoder struct {
+	buffer bytes.Buffer
+}
+
+func (enc *canonicalEncoder) writeUint32(value uint32) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], value)
+	enc.buffer.Write(data[:])
+}
+
+func (enc *canonicalEncoder) writeInt(value int64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(value))
+	enc.buffer.Write(data[:])
+}
+
+func (enc *canonicalEncoder) writeBytes(data []byte) {
+	enc.writeUint32(uint32(len(data)))
+	enc.buffer.Write(data)
+}
+
+func (enc *canonicalEncoder) Bytes() []byte {
+	return enc.buffer.Bytes()
+}
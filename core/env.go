@@ -2,8 +2,12 @@ package core
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config specifies configuration properties
@@ -11,17 +15,39 @@ type Config interface {
 	GetDbFile(nodeID string) string
 	GetDbBucket() string
 	GetDbUtxoBucket() string
-	GetBlockReward() int
-	GetGenesisData() string
 	GetWalletStoreFile(nodeID string) string
+	GetHost() string
+	GetSeedPeers() []string
+	GetMaxOutboundPeers() int
+	GetMaxInboundPeers() int
+	GetRPCAddress(nodeID string) string
+	GetPeersFile(nodeID string) string
+	GetBansFile(nodeID string) string
+	GetBanThreshold() int
+	GetBanDuration() time.Duration
+	GetTransportSecurity() string
+	GetNodeKeyFile(nodeID string) string
+	GetAllowedPeerKeys() []string
+	GetMempoolFile(nodeID string) string
+	GetMempoolMaxBytes() int
+	GetMempoolExpiry() time.Duration
+	GetMempoolSaveInterval() time.Duration
+	GetBlockMaxBytes() int
+	GetMinerInterval() time.Duration
+	GetMineEmptyBlocks() bool
+	GetMinerThreads() int
+	GetNetwork() string
+	GetAuthorities() []string
+	GetCheckpoints() []Checkpoint
+	GetAssumeValid() Checkpoint
 }
 
 // EnvConfig implements Config via environment
 type EnvConfig struct{}
 
-// GetDbFile gets BOLT_DB_FILE
+// GetDbFile gets BOLT_DB_FILE of node on configured network
 func (env *EnvConfig) GetDbFile(nodeID string) string {
-	return fmt.Sprintf(env.Get("BOLT_DB_FILE"), nodeID)
+	return env.networkFile(env.Get("BOLT_DB_FILE"), nodeID)
 }
 
 // GetDbBucket gets BOLT_DB_BUCKET
@@ -34,19 +60,175 @@ func (env *EnvConfig) GetDbUtxoBucket() string {
 	return env.Get("BOLT_DB_UTXO_BUCKET")
 }
 
-// GetBlockReward gets BLOCK_REWARD
-func (env *EnvConfig) GetBlockReward() int {
-	return env.GetInt("BLOCK_REWARD")
+// GetWalletStoreFile gets WALLET_STORE_FILE of node on configured network
+func (env *EnvConfig) GetWalletStoreFile(nodeID string) string {
+	return env.networkFile(env.Get("WALLET_STORE_FILE"), nodeID)
 }
 
-// GetGenesisData gets GENESIS_DATA
-func (env *EnvConfig) GetGenesisData() string {
-	return env.Get("GENESIS_DATA")
+// GetHost gets NODE_HOST
+func (env *EnvConfig) GetHost() string {
+	return env.GetOrDefault("NODE_HOST", "localhost")
 }
 
-// GetWalletStoreFile gets WALLET_STORE_FILE
-func (env *EnvConfig) GetWalletStoreFile(nodeID string) string {
-	return fmt.Sprintf(env.Get("WALLET_STORE_FILE"), nodeID)
+// GetSeedPeers gets comma separated SEED_PEERS, seed peers of configured network when not set.
+// Seeds without port use default port of network.
+func (env *EnvConfig) GetSeedPeers() []string {
+	seeds := env.GetList("SEED_PEERS")
+	if len(seeds) == 0 {
+		seeds = env.params().SeedPeers
+	}
+	var peers []string
+	for _, seed := range seeds {
+		peers = append(peers, env.params().PeerAddress(seed))
+	}
+	return peers
+}
+
+// GetMaxOutboundPeers gets MAX_OUTBOUND_PEERS
+func (env *EnvConfig) GetMaxOutboundPeers() int {
+	return env.GetIntOrDefault("MAX_OUTBOUND_PEERS", 8)
+}
+
+// GetMaxInboundPeers gets MAX_INBOUND_PEERS
+func (env *EnvConfig) GetMaxInboundPeers() int {
+	return env.GetIntOrDefault("MAX_INBOUND_PEERS", 32)
+}
+
+// GetRPCAddress gets RPC_ADDRESS, it defaults to localhost on RPC port of node given by network parameters
+func (env *EnvConfig) GetRPCAddress(nodeID string) string {
+	return env.GetOrDefault("RPC_ADDRESS", net.JoinHostPort("localhost", env.params().RPCPort(nodeID)))
+}
+
+// GetPeersFile gets PEERS_FILE of node on configured network
+func (env *EnvConfig) GetPeersFile(nodeID string) string {
+	return env.networkFile(env.GetOrDefault("PEERS_FILE", "peers_%s.dat"), nodeID)
+}
+
+// GetBansFile gets BANS_FILE of node on configured network
+func (env *EnvConfig) GetBansFile(nodeID string) string {
+	return env.networkFile(env.GetOrDefault("BANS_FILE", "bans_%s.dat"), nodeID)
+}
+
+// GetBanThreshold gets BAN_THRESHOLD
+func (env *EnvConfig) GetBanThreshold() int {
+	return env.GetIntOrDefault("BAN_THRESHOLD", 100)
+}
+
+// GetBanDuration gets BAN_DURATION
+func (env *EnvConfig) GetBanDuration() time.Duration {
+	return env.GetDurationOrDefault("BAN_DURATION", 24*time.Hour)
+}
+
+// GetTransportSecurity gets TRANSPORT_SECURITY, plain or tls
+func (env *EnvConfig) GetTransportSecurity() string {
+	return env.GetOrDefault("TRANSPORT_SECURITY", TransportPlain)
+}
+
+// GetNodeKeyFile gets NODE_KEY_FILE
+func (env *EnvConfig) GetNodeKeyFile(nodeID string) string {
+	return fmt.Sprintf(env.GetOrDefault("NODE_KEY_FILE", "node_key_%s.pem"), nodeID)
+}
+
+// GetAllowedPeerKeys gets comma separated ALLOWED_PEER_KEYS fingerprints
+func (env *EnvConfig) GetAllowedPeerKeys() []string {
+	return env.GetList("ALLOWED_PEER_KEYS")
+}
+
+// GetMempoolFile gets MEMPOOL_FILE of node on configured network
+func (env *EnvConfig) GetMempoolFile(nodeID string) string {
+	return env.networkFile(env.GetOrDefault("MEMPOOL_FILE", "mempool_%s.dat"), nodeID)
+}
+
+// GetMempoolMaxBytes gets MEMPOOL_MAX_BYTES
+func (env *EnvConfig) GetMempoolMaxBytes() int {
+	return env.GetIntOrDefault("MEMPOOL_MAX_BYTES", 32<<20)
+}
+
+// GetMempoolExpiry gets MEMPOOL_EXPIRY
+func (env *EnvConfig) GetMempoolExpiry() time.Duration {
+	return env.GetDurationOrDefault("MEMPOOL_EXPIRY", 336*time.Hour)
+}
+
+// GetMempoolSaveInterval gets MEMPOOL_SAVE_INTERVAL, zero disables periodic saving
+func (env *EnvConfig) GetMempoolSaveInterval() time.Duration {
+	return env.GetDurationOrDefault("MEMPOOL_SAVE_INTERVAL", 0)
+}
+
+// GetBlockMaxBytes gets BLOCK_MAX_BYTES
+func (env *EnvConfig) GetBlockMaxBytes() int {
+	return env.GetIntOrDefault("BLOCK_MAX_BYTES", 1<<20)
+}
+
+// GetMinerInterval gets MINER_INTERVAL, zero disables mining at interval
+func (env *EnvConfig) GetMinerInterval() time.Duration {
+	return env.GetDurationOrDefault("MINER_INTERVAL", 30*time.Second)
+}
+
+// GetMineEmptyBlocks gets MINE_EMPTY_BLOCKS
+func (env *EnvConfig) GetMineEmptyBlocks() bool {
+	return env.GetBoolOrDefault("MINE_EMPTY_BLOCKS", false)
+}
+
+// GetMinerThreads gets MINER_THREADS, all cpus are used when not set
+func (env *EnvConfig) GetMinerThreads() int {
+	return env.GetIntOrDefault("MINER_THREADS", runtime.NumCPU())
+}
+
+// GetNetwork gets NETWORK, main network when not set
+func (env *EnvConfig) GetNetwork() string {
+	return env.GetOrDefault("NETWORK", NetworkMain)
+}
+
+// GetAuthorities gets AUTHORITIES, addresses sealing blocks of proof of authority network in turn
+func (env *EnvConfig) GetAuthorities() []string {
+	authorities := env.GetList("AUTHORITIES")
+	if len(authorities) == 0 {
+		authorities = env.params().Authorities
+	}
+	return authorities
+}
+
+// GetCheckpoints gets comma separated CHECKPOINTS written as height:hash, they are added to checkpoints of network.
+// Invalid checkpoints are skipped.
+func (env *EnvConfig) GetCheckpoints() []Checkpoint {
+	var checkpoints []Checkpoint
+	for _, value := range env.GetList("CHECKPOINTS") {
+		checkpoint, err := ParseCheckpoint(value)
+		if err != nil {
+			fmt.Printf("skipping checkpoint: %s\n", err)
+			continue
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints
+}
+
+// GetAssumeValid gets ASSUME_VALID written as height:hash, it replaces assume valid block of network.
+// Empty checkpoint is returned when it is not set or invalid.
+func (env *EnvConfig) GetAssumeValid() Checkpoint {
+	value := env.Get("ASSUME_VALID")
+	if value == "" {
+		return Checkpoint{}
+	}
+	checkpoint, err := ParseCheckpoint(value)
+	if err != nil {
+		fmt.Printf("skipping assume valid block: %s\n", err)
+	}
+	return checkpoint
+}
+
+// params gets parameters of configured network, main network parameters when it is unknown
+func (env *EnvConfig) params() *ChainParams {
+	params, err := ParamsForNetwork(env.GetNetwork())
+	if err != nil {
+		return &MainParams
+	}
+	return params
+}
+
+// networkFile formats file name of node with network and node id, so files of different networks do not collide
+func (env *EnvConfig) networkFile(format string, nodeID string) string {
+	return fmt.Sprintf(format, env.GetNetwork()+"_"+nodeID)
 }
 
 // Get gets string value from config
@@ -54,6 +236,54 @@ func (env *EnvConfig) Get(key string) string {
 	return os.Getenv(key)
 }
 
+// GetOrDefault gets string value from config or default when not set
+func (env *EnvConfig) GetOrDefault(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// GetList gets comma separated values from config
+func (env *EnvConfig) GetList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// GetIntOrDefault gets intiger value from config or default when not set
+func (env *EnvConfig) GetIntOrDefault(key string, defaultValue int) int {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return int(value)
+}
+
+// GetDurationOrDefault gets duration value like 90s or 24h from config or default when not set
+func (env *EnvConfig) GetDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetBoolOrDefault gets boolean value like true or 0 from config or default when not set
+func (env *EnvConfig) GetBoolOrDefault(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 // GetInt gets intiger value from config
 func (env *EnvConfig) GetInt(key string) int {
 	value, _ := strconv.ParseInt(os.Getenv(key), 10, 64)
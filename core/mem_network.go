@@ -0,0 +1,311 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemListenBacklog is number of dialed connections waiting to be accepted
+const MemListenBacklog = 16
+
+// MemNetwork connects nodes running in single process without sockets.
+// Every write is delivered after configured latency or dropped with configured loss rate,
+// message framing keeps single message in single write so loss drops whole messages.
+// Partitions close links between groups of addresses and refuse new ones until healed.
+// Loss is drawn from seeded source to make test runs repeatable.
+// It is safe for concurrent use.
+type MemNetwork struct {
+	listeners  map[string]*memListener
+	conns      map[*memConn]bool
+	partitions map[[2]string]bool
+	latency    time.Duration
+	loss       float64
+	rand       *rand.Rand
+	nextPort   int
+	mu         sync.Mutex
+}
+
+// NewMemNetwork creates in-memory network with seeded message loss
+func NewMemNetwork(seed int64) *MemNetwork {
+	return &MemNetwork{
+		listeners:  make(map[string]*memListener),
+		conns:      make(map[*memConn]bool),
+		partitions: make(map[[2]string]bool),
+		rand:       rand.New(rand.NewSource(seed)),
+		nextPort:   40000,
+	}
+}
+
+// Transport gets transport of node listening on address
+func (n *MemNetwork) Transport(address string) Transport {
+	return &MemTransport{n, address}
+}
+
+// SetLatency sets delivery delay of every write
+func (n *MemNetwork) SetLatency(latency time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency = latency
+}
+
+// SetLoss sets probability of dropping a write, between 0 and 1
+func (n *MemNetwork) SetLoss(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loss = rate
+}
+
+// Partition breaks links between every address of a and every address of b
+func (n *MemNetwork) Partition(a, b []string) {
+	n.mu.Lock()
+	for _, x := range a {
+		for _, y := range b {
+			n.partitions[link(x, y)] = true
+		}
+	}
+	var broken []*memConn
+	for c := range n.conns {
+		if n.partitions[link(c.node, c.peerNode)] {
+			broken = append(broken, c)
+		}
+	}
+	n.mu.Unlock()
+	for _, c := range broken {
+		c.Close()
+	}
+}
+
+// Heal removes all partitions
+func (n *MemNetwork) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partitions = make(map[[2]string]bool)
+}
+
+func link(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func (n *MemNetwork) listen(address string) (net.Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listeners[address] != nil {
+		return nil, fmt.Errorf("address %s already in use", address)
+	}
+	l := &memListener{
+		network: n,
+		address: address,
+		accept:  make(chan net.Conn, MemListenBacklog),
+		quit:    make(chan struct{}),
+	}
+	n.listeners[address] = l
+	return l, nil
+}
+
+func (n *MemNetwork) dial(from, to string) (net.Conn, error) {
+	n.mu.Lock()
+	l := n.listeners[to]
+	if l == nil {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: connection refused", to)
+	}
+	if n.partitions[link(from, to)] {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: network is unreachable", to)
+	}
+	host, _, err := net.SplitHostPort(from)
+	if err != nil {
+		host = from
+	}
+	n.nextPort++
+	ephemeral := memAddr(net.JoinHostPort(host, strconv.Itoa(n.nextPort)))
+	up, down := newMemPipe(), newMemPipe()
+	client := &memConn{network: n, node: from, peerNode: to, local: ephemeral, remote: memAddr(to), in: down, out: up}
+	server := &memConn{network: n, node: to, peerNode: from, local: memAddr(to), remote: ephemeral, in: up, out: down}
+	n.conns[client] = true
+	n.conns[server] = true
+	n.mu.Unlock()
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.quit:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("dial %s: connection refused", to)
+	}
+}
+
+// deliveryTime gets time when write should be delivered, false when write is lost
+func (n *MemNetwork) deliveryTime() (time.Time, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.loss > 0 && n.rand.Float64() < n.loss {
+		return time.Time{}, false
+	}
+	return time.Now().Add(n.latency), true
+}
+
+// MemTransport is transport of single node in memory network
+type MemTransport struct {
+	network *MemNetwork
+	address string
+}
+
+// Listen listens on address in memory network
+func (t *MemTransport) Listen(address string) (net.Listener, error) {
+	return t.network.listen(address)
+}
+
+// Dial connects to address in memory network
+func (t *MemTransport) Dial(address string) (net.Conn, error) {
+	return t.network.dial(t.address, address)
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+type memListener struct {
+	network *MemNetwork
+	address string
+	accept  chan net.Conn
+	quit    chan struct{}
+	once    sync.Once
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.quit:
+		return nil, fmt.Errorf("accept %s: listener closed", l.address)
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		close(l.quit)
+		l.network.mu.Lock()
+		delete(l.network.listeners, l.address)
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr(l.address)
+}
+
+// memConn is one side of in-memory connection, node is address of its owner
+type memConn struct {
+	network  *MemNetwork
+	node     string
+	peerNode string
+	local    net.Addr
+	remote   net.Addr
+	in       *memPipe
+	out      *memPipe
+}
+
+func (c *memConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *memConn) Write(b []byte) (int, error) {
+	at, delivered := c.network.deliveryTime()
+	if !delivered {
+		return len(b), nil
+	}
+	return c.out.write(b, at)
+}
+
+// Close discards unread data and signals end of stream to the other side
+func (c *memConn) Close() error {
+	c.in.close(true)
+	c.out.close(false)
+	c.network.mu.Lock()
+	delete(c.network.conns, c)
+	c.network.mu.Unlock()
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr                { return c.local }
+func (c *memConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *memConn) SetDeadline(t time.Time) error      { return nil }
+func (c *memConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *memConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// memPipe is one direction of in-memory connection
+type memPipe struct {
+	chunks []memChunk
+	closed bool
+	mu     sync.Mutex
+	cond   *sync.Cond
+}
+
+// memChunk is single write delivered at given time
+type memChunk struct {
+	data []byte
+	at   time.Time
+}
+
+func newMemPipe() *memPipe {
+	p := &memPipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *memPipe) write(b []byte, at time.Time) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.chunks = append(p.chunks, memChunk{append([]byte{}, b...), at})
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *memPipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for len(p.chunks) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if wait := time.Until(p.chunks[0].at); wait > 0 {
+			p.mu.Unlock()
+			time.Sleep(wait)
+			p.mu.Lock()
+			continue
+		}
+		n := copy(b, p.chunks[0].data)
+		p.chunks[0].data = p.chunks[0].data[n:]
+		if len(p.chunks[0].data) == 0 {
+			p.chunks = p.chunks[1:]
+		}
+		return n, nil
+	}
+}
+
+func (p *memPipe) close(discard bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if discard {
+		p.chunks = nil
+	}
+	p.cond.Broadcast()
+}
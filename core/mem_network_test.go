@@ -0,0 +1,383 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig keeps node files in temporary directory and connects node to given seeds,
+// node runs on given network or on network of environment
+type testConfig struct {
+	EnvConfig
+	dir     string
+	seeds   []string
+	network string
+}
+
+func (c *testConfig) GetNetwork() string {
+	if c.network != "" {
+		return c.network
+	}
+	return c.EnvConfig.GetNetwork()
+}
+
+func (c *testConfig) GetDbFile(nodeID string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("chain_%s.db", nodeID))
+}
+
+func (c *testConfig) GetWalletStoreFile(nodeID string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("wallets_%s.dat", nodeID))
+}
+
+func (c *testConfig) GetPeersFile(nodeID string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("peers_%s.dat", nodeID))
+}
+
+func (c *testConfig) GetBansFile(nodeID string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("bans_%s.dat", nodeID))
+}
+
+func (c *testConfig) GetRPCAddress(nodeID string) string {
+	return "127.0.0.1:0"
+}
+
+func (c *testConfig) GetSeedPeers() []string {
+	return c.seeds
+}
+
+func (c *testConfig) GetMempoolFile(nodeID string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("mempool_%s.dat", nodeID))
+}
+
+func (c *testConfig) GetTransportSecurity() string {
+	return TransportPlain
+}
+
+func newTestNode(t *testing.T, network *MemNetwork, dir, port string, seeds ...string) *Node {
+	return newTestConfigNode(t, network, &testConfig{dir: dir, seeds: seeds}, port)
+}
+
+func newTestConfigNode(t *testing.T, network *MemNetwork, env *testConfig, port string) *Node {
+	chain := InitChain(env, port)
+	node := NewNodeWithChain(env, port, "", chain)
+	node.Transport = network.Transport(node.Address)
+	node.Peers.connectInterval = 100 * time.Millisecond
+	t.Cleanup(node.Stop)
+	return node
+}
+
+func mineTestBlocks(t *testing.T, node *Node, n int, address string) []*Transaction {
+	var cbTxs []*Transaction
+	for i := 0; i < n; i++ {
+		cbTx := NewCoinbaseTransaction(address, fmt.Sprintf("%s block %d", node.Port, i), node.Chain.Params.BlockSubsidy(i+1))
+		_, err := node.Chain.MineBlock([]*Transaction{cbTx})
+		assert.Nil(t, err)
+		cbTxs = append(cbTxs, cbTx)
+	}
+	return cbTxs
+}
+
+func newTestSpend(chain *Blockchain, from *Wallet, prev *Transaction, to string) *Transaction {
+	in := TxInput{prev.ID, 0, nil, from.PublicKey, SequenceFinal}
+	tx := &Transaction{nil, []TxInput{in}, []TxOutput{*NewTxOutput(prev.Vout[0].Value, to)}}
+	tx.ID = tx.Hash()
+	chain.SignTransaction(&from.PrivateKey, tx)
+	return tx
+}
+
+func chainTip(node *Node) []byte {
+	var tip []byte
+	node.Chain.db.View(func(tx *bolt.Tx) error {
+		tip = append(tip, tx.Bucket([]byte(node.Env.GetDbBucket())).Get([]byte("1"))...)
+		return nil
+	})
+	return tip
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("condition not met in %s", timeout)
+	return false
+}
+
+func TestMemNetworkLatencyAndLoss(t *testing.T) {
+	network := NewMemNetwork(7)
+	listen, err := network.Transport("a:1").Listen("a:1")
+	assert.Nil(t, err)
+	network.SetLatency(30 * time.Millisecond)
+	client, err := network.Transport("b:1").Dial("a:1")
+	assert.Nil(t, err)
+	server, _ := listen.Accept()
+	assert.Equal(t, "b", client.LocalAddr().String()[:1])
+	assert.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String())
+
+	start := time.Now()
+	client.Write([]byte("hello"))
+	buff := make([]byte, 5)
+	_, err = io.ReadFull(server, buff)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buff))
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+
+	network.SetLatency(0)
+	network.SetLoss(0.5)
+	delivered := 0
+	for i := 0; i < 100; i++ {
+		client.Write([]byte{byte(i)})
+	}
+	client.Close()
+	for {
+		_, err := server.Read(buff[:1])
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		delivered++
+	}
+	assert.True(t, delivered > 20 && delivered < 80)
+}
+
+func TestMemNetworkPartition(t *testing.T) {
+	network := NewMemNetwork(1)
+	listen, _ := network.Transport("a:1").Listen("a:1")
+	client, err := network.Transport("b:1").Dial("a:1")
+	assert.Nil(t, err)
+	server, _ := listen.Accept()
+
+	network.Partition([]string{"a:1"}, []string{"b:1"})
+	_, err = server.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	_, err = client.Write([]byte("x"))
+	assert.NotNil(t, err)
+	_, err = network.Transport("b:1").Dial("a:1")
+	assert.Contains(t, err.Error(), "unreachable")
+	_, err = network.Transport("c:1").Dial("a:1")
+	assert.Nil(t, err)
+
+	network.Heal()
+	_, err = network.Transport("b:1").Dial("a:1")
+	assert.Nil(t, err)
+}
+
+func TestNodesConverge(t *testing.T) {
+	dir := t.TempDir()
+	network := NewMemNetwork(1)
+	network.SetLatency(5 * time.Millisecond)
+	a := newTestNode(t, network, dir, "4101")
+	b := newTestNode(t, network, dir, "4102", a.Address)
+	c := newTestNode(t, network, dir, "4103", b.Address)
+	mineTestBlocks(t, a, 3, string(NewWallet().GetAddress()))
+	for _, node := range []*Node{a, b, c} {
+		go node.Start(context.Background())
+	}
+	waitFor(t, 30*time.Second, func() bool {
+		tip := chainTip(a)
+		return string(chainTip(b)) == string(tip) && string(chainTip(c)) == string(tip)
+	})
+	assert.Equal(t, 3, GetBestHeight(c.Chain.db, c.Env))
+}
+
+func TestNodesConvergeAfterPartition(t *testing.T) {
+	dir := t.TempDir()
+	network := NewMemNetwork(1)
+	a := newTestNode(t, network, dir, "4201")
+	b := newTestNode(t, network, dir, "4202", a.Address)
+	mineTestBlocks(t, a, 1, string(NewWallet().GetAddress()))
+	go a.Start(context.Background())
+	go b.Start(context.Background())
+	waitFor(t, 30*time.Second, func() bool {
+		return string(chainTip(a)) == string(chainTip(b))
+	})
+
+	network.Partition([]string{a.Address}, []string{b.Address})
+	mineTestBlocks(t, a, 2, string(NewWallet().GetAddress()))
+	time.Sleep(200 * time.Millisecond)
+	assert.NotEqual(t, chainTip(a), chainTip(b))
+
+	network.Heal()
+	waitFor(t, 30*time.Second, func() bool {
+		return string(chainTip(a)) == string(chainTip(b))
+	})
+	assert.Equal(t, 3, GetBestHeight(b.Chain.db, b.Env))
+}
+
+func TestNodeStop(t *testing.T) {
+	dir := t.TempDir()
+	network := NewMemNetwork(1)
+	a := newTestNode(t, network, dir, "4301")
+	b := newTestNode(t, network, dir, "4302", a.Address)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- a.Start(ctx)
+	}()
+	go b.Start(context.Background())
+	waitFor(t, 30*time.Second, func() bool {
+		return len(a.Peers.Peers()) == 1 && len(b.Peers.Peers()) == 1
+	})
+
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node did not stop")
+	}
+	assert.Empty(t, a.Peers.Peers())
+	assert.FileExists(t, a.Env.GetMempoolFile(a.Port))
+	_, err := a.Chain.db.Begin(false)
+	assert.Equal(t, bolt.ErrDatabaseNotOpen, err)
+	_, err = network.Transport(b.Address).Dial(a.Address)
+	assert.NotNil(t, err)
+	waitFor(t, 5*time.Second, func() bool {
+		return len(b.Peers.Peers()) == 0
+	})
+}
+
+func TestNodesConcurrentTransactions(t *testing.T) {
+	dir := t.TempDir()
+	network := NewMemNetwork(1)
+	network.SetLatency(time.Millisecond)
+	a := newTestNode(t, network, dir, "4401")
+	b := newTestNode(t, network, dir, "4402", a.Address)
+	c := newTestNode(t, network, dir, "4403", a.Address)
+	a.MinersAdds = string(NewWallet().GetAddress())
+	wallet := NewWallet()
+	receiver := string(NewWallet().GetAddress())
+	cbTxs := mineTestBlocks(t, a, 6, string(wallet.GetAddress()))
+	var txs []*Transaction
+	for _, cbTx := range cbTxs {
+		txs = append(txs, newTestSpend(a.Chain, wallet, cbTx, receiver))
+	}
+	for _, node := range []*Node{a, b, c} {
+		go node.Start(context.Background())
+	}
+	waitFor(t, 30*time.Second, func() bool {
+		_, height := a.Chain.Tip()
+		_, heightB := b.Chain.Tip()
+		_, heightC := c.Chain.Tip()
+		return heightB == height && heightC == height
+	})
+
+	var wg sync.WaitGroup
+	for i, tx := range txs {
+		sender := b
+		if i%2 == 1 {
+			sender = c
+		}
+		wg.Add(1)
+		go func(sender *Node, tx *Transaction) {
+			defer wg.Done()
+			peer, err := sender.Peers.Connect(a.Address)
+			if assert.NoError(t, err) {
+				sender.SendTransaction(peer, tx)
+			}
+		}(sender, tx)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			a.Peers.PeerInfos()
+			a.Mempool.Txs()
+			a.Chain.Tip()
+		}
+	}()
+	wg.Wait()
+
+	waitFor(t, 30*time.Second, func() bool {
+		for _, tx := range txs {
+			_, err := a.Chain.GetTransaction(tx.ID)
+			if err != nil {
+				return false
+			}
+		}
+		return a.Mempool.Len() == 0
+	})
+	waitFor(t, 30*time.Second, func() bool {
+		tip, _ := a.Chain.Tip()
+		tipB, _ := b.Chain.Tip()
+		tipC, _ := c.Chain.Tip()
+		return bytes.Equal(tip, tipB) && bytes.Equal(tip, tipC)
+	})
+}
+
+func TestInvalidBlockResetsSync(t *testing.T) {
+	network := NewMemNetwork(1)
+	node := newTestNode(t, network, t.TempDir(), "4501")
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := NewPeer(local, "localhost:4502", false, &MainParams)
+	node.syncPeer = peer.Addr()
+	node.queueBlocks([][]byte{[]byte("first"), []byte("second")})
+
+	genesis := node.Chain.Genesis()
+	cbTx := NewCoinbaseTransaction(string(NewWallet().GetAddress()), "unsealed", 50)
+	block := &Block{genesis.Timestamp + 600, []*Transaction{cbTx}, genesis.Hash, []byte{}, 0, 1, Difficulty + 1, nil, nil}
+	block.Hash = block.computeHash()
+	err := node.ReceiveBlockCommand(peer, EncodeData(BlockCommand{block.Serialize()}), node.Env)
+	assert.True(t, isProtocolError(err))
+	assert.Equal(t, 0, node.transitLen())
+	assert.Equal(t, "", node.syncPeer)
+	peer.Close()
+}
+
+func TestNodeExpiresMempool(t *testing.T) {
+	network := NewMemNetwork(1)
+	node := newTestNode(t, network, t.TempDir(), "4601")
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := mineTestBlocks(t, node, 1, address)[0]
+	tx := newTestSpend(node.Chain, wallet, cbTx, address)
+	assert.Nil(t, node.Mempool.Add(*tx))
+	go node.expireMempoolPeriodically(10 * time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, node.Mempool.Len())
+	node.Mempool.mu.Lock()
+	node.Mempool.entries[hex.EncodeToString(tx.ID)].Time = time.Now().Add(-node.Mempool.expiry - time.Minute)
+	node.Mempool.mu.Unlock()
+	waitFor(t, 5*time.Second, func() bool {
+		return node.Mempool.Len() == 0
+	})
+}
+
+func TestNodesOnDifferentNetworks(t *testing.T) {
+	dir := t.TempDir()
+	network := NewMemNetwork(1)
+	a := newTestNode(t, network, dir, "4801")
+	b := newTestConfigNode(t, network, &testConfig{dir: dir, seeds: []string{a.Address}, network: NetworkRegtest}, "4802")
+	c := newTestNode(t, network, dir, "4803", a.Address)
+	assert.Equal(t, NetworkMain, a.Chain.Params.Name)
+	assert.Equal(t, NetworkRegtest, b.Chain.Params.Name)
+	assert.True(t, RegtestParams.IsGenesis(b.Chain.Genesis().Hash))
+	mineTestBlocks(t, a, 2, string(NewWallet().GetAddress()))
+	for _, node := range []*Node{a, b, c} {
+		go node.Start(context.Background())
+	}
+	waitFor(t, 30*time.Second, func() bool {
+		return string(chainTip(a)) == string(chainTip(c))
+	})
+	_, height := b.Chain.Tip()
+	assert.Equal(t, 0, height)
+	for _, peer := range b.Peers.Peers() {
+		assert.False(t, peer.HandshakeComplete())
+	}
+}
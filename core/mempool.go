@@ -0,0 +1,507 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMempoolDuplicate is returned for transaction that is already pending
+	ErrMempoolDuplicate = errors.New("transaction already in mempool")
+	// ErrMempoolConflict is returned for transaction spending output already spent by pending transaction
+	ErrMempoolConflict = errors.New("transaction conflicts with pending transaction")
+	// ErrMissingInputs is returned for transaction spending unknown or spent outputs
+	ErrMissingInputs = errors.New("transaction inputs missing or spent")
+	// ErrMempoolFull is returned when transaction fee rate is too low to stay in full mempool
+	ErrMempoolFull = errors.New("mempool full, transaction fee rate too low")
+	// ErrReplacementFee is returned for replacement not paying more than transactions it replaces
+	ErrReplacementFee = errors.New("replacement transaction fee too low")
+)
+
+// MempoolExpireInterval is how often running node drops expired transactions
+const MempoolExpireInterval = time.Minute
+
+// MaxReplacementEvictions is the maximum number of pending transactions removed by single replacement
+const MaxReplacementEvictions = 100
+
+// MempoolEntry is pending transaction with its fee, size and time it was accepted
+type MempoolEntry struct {
+	Tx   Transaction
+	Fee  int
+	Size int
+	Time time.Time
+	seq  uint64
+}
+
+// FeeRate gets fee per 1000 bytes of transaction
+func (entry *MempoolEntry) FeeRate() float64 {
+	return float64(entry.Fee) * 1000 / float64(entry.Size)
+}
+
+// Mempool holds validated transactions waiting to be mined.
+// Pending transactions spend unspent outputs of main chain or outputs of other
+// pending transactions, and no two of them spend the same output.
+// It is safe for concurrent use.
+type Mempool struct {
+	utxos    *UtxoStore
+	entries  map[string]*MempoolEntry
+	spends   map[string]string
+	bytes    int
+	maxBytes int
+	expiry   time.Duration
+	seq      uint64
+	mu       sync.RWMutex
+}
+
+// NewMempool creates mempool validating transactions against utxo index of chain
+func NewMempool(chain *Blockchain, maxBytes int, expiry time.Duration) *Mempool {
+	return &Mempool{
+		utxos:    &UtxoStore{chain},
+		entries:  make(map[string]*MempoolEntry),
+		spends:   make(map[string]string),
+		maxBytes: maxBytes,
+		expiry:   expiry,
+	}
+}
+
+// outpoint makes key of transaction output
+func outpoint(txid []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txid, vout)
+}
+
+// Add validates transaction and adds it to pool.
+// Transaction spending outputs of pending transactions that signal replace-by-fee replaces them
+// together with their descendants when it pays higher fee than all of them and higher fee rate
+// than each directly replaced transaction.
+// Expired transactions are dropped first and transactions with lowest fee rate
+// are evicted while pool is over its size limit. When replacement itself is evicted,
+// pool is restored with the transactions it would replace.
+// Invalid transactions are reported as misbehavior.
+func (mp *Mempool) Add(tx Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.expire(time.Now())
+	entry, conflicts, err := mp.validate(tx)
+	if err != nil {
+		return err
+	}
+	var saved mempoolSnapshot
+	if len(conflicts) > 0 {
+		err = mp.checkReplacement(entry, conflicts)
+		if err != nil {
+			return err
+		}
+		saved = mp.snapshot()
+		for _, id := range conflicts {
+			mp.remove(id, true)
+		}
+	}
+	mp.add(entry)
+	mp.trim()
+	if mp.entries[hex.EncodeToString(tx.ID)] == nil {
+		if len(conflicts) > 0 {
+			mp.restore(saved)
+		}
+		return ErrMempoolFull
+	}
+	if len(conflicts) > 0 {
+		fmt.Printf("transaction %x replaced %d pending transactions\n", tx.ID, len(conflicts))
+	}
+	return nil
+}
+
+// mempoolSnapshot holds pool contents restored when replacement is evicted from full pool,
+// so replaced transactions are not lost together with it
+type mempoolSnapshot struct {
+	entries map[string]*MempoolEntry
+	spends  map[string]string
+	bytes   int
+}
+
+func (mp *Mempool) snapshot() mempoolSnapshot {
+	saved := mempoolSnapshot{make(map[string]*MempoolEntry), make(map[string]string), mp.bytes}
+	for id, entry := range mp.entries {
+		saved.entries[id] = entry
+	}
+	for key, id := range mp.spends {
+		saved.spends[key] = id
+	}
+	return saved
+}
+
+func (mp *Mempool) restore(saved mempoolSnapshot) {
+	mp.entries = saved.entries
+	mp.spends = saved.spends
+	mp.bytes = saved.bytes
+}
+
+// validate checks transaction against utxo index and pending transactions and computes its fee,
+// ids of pending transactions spending the same outputs are returned as conflicts
+func (mp *Mempool) validate(tx Transaction) (*MempoolEntry, []string, error) {
+	err := CheckTransactionSanity(&tx, mp.utxos.Chain.Params)
+	if err != nil {
+		return nil, nil, misbehavior(ScoreInvalidTransaction, "invalid transaction %x: %s", tx.ID, err)
+	}
+	if tx.IsCoinbase() {
+		return nil, nil, misbehavior(ScoreInvalidTransaction, "coinbase transaction %x outside block", tx.ID)
+	}
+	if mp.entries[hex.EncodeToString(tx.ID)] != nil {
+		return nil, nil, ErrMempoolDuplicate
+	}
+	previousTxs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	var conflicts []string
+	in := 0
+	for _, vin := range tx.Vin {
+		key := outpoint(vin.Txid, vin.Vout)
+		if spent[key] {
+			return nil, nil, misbehavior(ScoreInvalidTransaction, "transaction %x spends output twice", tx.ID)
+		}
+		spent[key] = true
+		if spender, ok := mp.spends[key]; ok && !contains(conflicts, spender) {
+			conflicts = append(conflicts, spender)
+		}
+		out, ok := mp.output(vin.Txid, vin.Vout)
+		if !ok {
+			return nil, nil, ErrMissingInputs
+		}
+		in += out.Value
+		addPreviousOutput(previousTxs, vin.Txid, vin.Vout, out)
+	}
+	out := 0
+	for _, vout := range tx.Vout {
+		out += vout.Value
+	}
+	if out > in {
+		return nil, nil, misbehavior(ScoreInvalidTransaction, "transaction %x outputs %d exceed inputs %d", tx.ID, out, in)
+	}
+	if !tx.Verify(previousTxs) {
+		return nil, nil, misbehavior(ScoreInvalidTransaction, "transaction %x failed verification", tx.ID)
+	}
+	entry := &MempoolEntry{Tx: tx, Fee: in - out, Size: len(tx.Serialize()), Time: time.Now()}
+	return entry, conflicts, nil
+}
+
+// checkReplacement checks replace-by-fee rules for entry replacing conflicting transactions
+func (mp *Mempool) checkReplacement(entry *MempoolEntry, conflicts []string) error {
+	evicted := make(map[string]bool)
+	for _, id := range conflicts {
+		original := mp.entries[id]
+		if !original.Tx.SignalsReplacement() {
+			return ErrMempoolConflict
+		}
+		if entry.FeeRate() <= original.FeeRate() {
+			fmt.Printf("replacement %x fee rate %.2f not above %.2f of %s\n", entry.Tx.ID, entry.FeeRate(), original.FeeRate(), id)
+			return ErrReplacementFee
+		}
+		mp.descendants(id, evicted)
+	}
+	if len(evicted) > MaxReplacementEvictions {
+		return fmt.Errorf("replacement would evict %d transactions, limit is %d", len(evicted), MaxReplacementEvictions)
+	}
+	fee := 0
+	for id := range evicted {
+		fee += mp.entries[id].Fee
+	}
+	if entry.Fee <= fee {
+		fmt.Printf("replacement %x fee %d not above %d of replaced transactions\n", entry.Tx.ID, entry.Fee, fee)
+		return ErrReplacementFee
+	}
+	for _, vin := range entry.Tx.Vin {
+		if evicted[hex.EncodeToString(vin.Txid)] {
+			return fmt.Errorf("replacement %x spends output of transaction it replaces", entry.Tx.ID)
+		}
+	}
+	return nil
+}
+
+// descendants adds id of pending transaction and all transactions spending its outputs to set
+func (mp *Mempool) descendants(id string, set map[string]bool) {
+	entry := mp.entries[id]
+	if entry == nil || set[id] {
+		return
+	}
+	set[id] = true
+	for i := range entry.Tx.Vout {
+		if child, ok := mp.spends[outpoint(entry.Tx.ID, i)]; ok {
+			mp.descendants(child, set)
+		}
+	}
+}
+
+// output gets output of pending transaction or unspent output of main chain
+func (mp *Mempool) output(txid []byte, vout int) (TxOutput, bool) {
+	if parent := mp.entries[hex.EncodeToString(txid)]; parent != nil {
+		if vout < 0 || vout >= len(parent.Tx.Vout) {
+			return TxOutput{}, false
+		}
+		return parent.Tx.Vout[vout], true
+	}
+	return mp.utxos.FindOutput(txid, vout)
+}
+
+func (mp *Mempool) add(entry *MempoolEntry) {
+	id := hex.EncodeToString(entry.Tx.ID)
+	mp.seq++
+	entry.seq = mp.seq
+	mp.entries[id] = entry
+	for _, vin := range entry.Tx.Vin {
+		mp.spends[outpoint(vin.Txid, vin.Vout)] = id
+	}
+	mp.bytes += entry.Size
+}
+
+// remove removes pending transaction, with descendants set transactions spending its outputs are removed too
+func (mp *Mempool) remove(id string, descendants bool) {
+	entry := mp.entries[id]
+	if entry == nil {
+		return
+	}
+	delete(mp.entries, id)
+	for _, vin := range entry.Tx.Vin {
+		delete(mp.spends, outpoint(vin.Txid, vin.Vout))
+	}
+	mp.bytes -= entry.Size
+	if !descendants {
+		return
+	}
+	for i := range entry.Tx.Vout {
+		if child, ok := mp.spends[outpoint(entry.Tx.ID, i)]; ok {
+			mp.remove(child, true)
+		}
+	}
+}
+
+// trim evicts transactions with lowest fee rate and their descendants until pool fits its size limit,
+// among equal fee rates the newest transaction is evicted
+func (mp *Mempool) trim() {
+	for mp.bytes > mp.maxBytes && len(mp.entries) > 0 {
+		var worst *MempoolEntry
+		for _, entry := range mp.entries {
+			if worst == nil || entry.FeeRate() < worst.FeeRate() ||
+				(entry.FeeRate() == worst.FeeRate() && entry.seq > worst.seq) {
+				worst = entry
+			}
+		}
+		fmt.Printf("mempool full, evicting transaction %x [feerate:%.2f]\n", worst.Tx.ID, worst.FeeRate())
+		mp.remove(hex.EncodeToString(worst.Tx.ID), true)
+	}
+}
+
+// Expire removes transactions pending longer than expiry together with their descendants,
+// returns number of removed transactions
+func (mp *Mempool) Expire(now time.Time) int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.expire(now)
+}
+
+func (mp *Mempool) expire(now time.Time) int {
+	count := len(mp.entries)
+	for id, entry := range mp.entries {
+		if now.Sub(entry.Time) > mp.expiry {
+			mp.remove(id, true)
+		}
+	}
+	removed := count - len(mp.entries)
+	if removed > 0 {
+		fmt.Printf("expired %d mempool transactions\n", removed)
+	}
+	return removed
+}
+
+// Update removes transactions confirmed in connected blocks and transactions conflicting with them.
+// Transactions of disconnected blocks return to the pool when they are still valid,
+// pending transactions left without their inputs are removed.
+func (mp *Mempool) Update(update ChainUpdate) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, block := range update.Connected {
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			id := hex.EncodeToString(tx.ID)
+			mp.remove(id, false)
+			for _, vin := range tx.Vin {
+				if spender, ok := mp.spends[outpoint(vin.Txid, vin.Vout)]; ok {
+					fmt.Printf("removing mempool transaction %s conflicting with block %x\n", spender, block.Hash)
+					mp.remove(spender, true)
+				}
+			}
+		}
+	}
+	if len(update.Disconnected) == 0 {
+		return
+	}
+	for i := len(update.Disconnected) - 1; i >= 0; i-- {
+		for _, tx := range update.Disconnected[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			entry, conflicts, err := mp.validate(*tx)
+			if err == nil && len(conflicts) == 0 {
+				mp.add(entry)
+			}
+		}
+	}
+	for id, entry := range mp.entries {
+		for _, vin := range entry.Tx.Vin {
+			if _, ok := mp.output(vin.Txid, vin.Vout); !ok {
+				mp.remove(id, true)
+				break
+			}
+		}
+	}
+	mp.trim()
+}
+
+// Entry gets pending transaction with its fee by hex encoded id
+func (mp *Mempool) Entry(id string) (MempoolEntry, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	entry := mp.entries[id]
+	if entry == nil {
+		return MempoolEntry{}, false
+	}
+	return *entry, true
+}
+
+// Get gets pending transaction by hex encoded id
+func (mp *Mempool) Get(id string) (Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	entry := mp.entries[id]
+	if entry == nil {
+		return Transaction{}, false
+	}
+	return entry.Tx, true
+}
+
+// Txs gets pending transactions in order they were accepted, parents always come before
+// transactions spending their outputs
+func (mp *Mempool) Txs() []Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	entries := mp.ordered()
+	txs := make([]Transaction, 0, len(entries))
+	for _, entry := range entries {
+		txs = append(txs, entry.Tx)
+	}
+	return txs
+}
+
+// ordered gets entries sorted by acceptance with parents moved before their children
+func (mp *Mempool) ordered() []*MempoolEntry {
+	entries := make([]*MempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	ordered := make([]*MempoolEntry, 0, len(entries))
+	visited := make(map[*MempoolEntry]bool)
+	var visit func(entry *MempoolEntry)
+	visit = func(entry *MempoolEntry) {
+		if visited[entry] {
+			return
+		}
+		visited[entry] = true
+		for _, vin := range entry.Tx.Vin {
+			if parent := mp.entries[hex.EncodeToString(vin.Txid)]; parent != nil {
+				visit(parent)
+			}
+		}
+		ordered = append(ordered, entry)
+	}
+	for _, entry := range entries {
+		visit(entry)
+	}
+	return ordered
+}
+
+// Save writes pending transactions with their acceptance time to file, returns number of saved transactions.
+// File is replaced atomically so crash during save keeps previous content.
+func (mp *Mempool) Save(file string) (int, error) {
+	mp.mu.RLock()
+	var entries []MempoolEntry
+	for _, entry := range mp.ordered() {
+		entries = append(entries, *entry)
+	}
+	mp.mu.RUnlock()
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(entries)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	_, err = tmp.Write(buffer.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return len(entries), os.Rename(tmp.Name(), file)
+}
+
+// Load adds transactions saved in file, revalidated against current tip.
+// Expired, confirmed and conflicting transactions are dropped,
+// returns numbers of loaded and dropped transactions.
+func (mp *Mempool) Load(file string) (int, int, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	var entries []MempoolEntry
+	err = gob.NewDecoder(bytes.NewReader(content)).Decode(&entries)
+	if err != nil {
+		return 0, 0, err
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	count := len(mp.entries)
+	now := time.Now()
+	for _, saved := range entries {
+		if now.Sub(saved.Time) > mp.expiry {
+			continue
+		}
+		entry, conflicts, err := mp.validate(saved.Tx)
+		if err != nil || len(conflicts) > 0 {
+			continue
+		}
+		entry.Time = saved.Time
+		mp.add(entry)
+	}
+	mp.trim()
+	loaded := len(mp.entries) - count
+	return loaded, len(entries) - loaded, nil
+}
+
+// Len gets number of pending transactions
+func (mp *Mempool) Len() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return len(mp.entries)
+}
+
+// Bytes gets total size of pending transactions
+func (mp *Mempool) Bytes() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return mp.bytes
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChain(t *testing.T) *Blockchain {
+	env := &testConfig{dir: t.TempDir()}
+	chain := InitChain(env, "pool")
+	t.Cleanup(func() { chain.Close() })
+	return chain
+}
+
+// fundTestWallet mines block paying reward to wallet
+func fundTestWallet(t *testing.T, chain *Blockchain, wallet *Wallet, data string) *Transaction {
+	cbTx := NewCoinbaseTransaction(string(wallet.GetAddress()), data, 50)
+	_, err := chain.MineBlock([]*Transaction{cbTx})
+	assert.Nil(t, err)
+	return cbTx
+}
+
+// newTestTx spends output of prev owned by wallet
+func newTestTx(from *Wallet, prev *Transaction, vout int, outs ...TxOutput) *Transaction {
+	in := TxInput{prev.ID, vout, nil, from.PublicKey, SequenceFinal}
+	tx := &Transaction{nil, []TxInput{in}, outs}
+	tx.ID = tx.Hash()
+	tx.Sign(&from.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+	return tx
+}
+
+// newTestRbfTx spends output of prev owned by wallet signalling replace-by-fee
+func newTestRbfTx(from *Wallet, prev *Transaction, vout int, outs ...TxOutput) *Transaction {
+	in := TxInput{prev.ID, vout, nil, from.PublicKey, MaxRBFSequence}
+	tx := &Transaction{nil, []TxInput{in}, outs}
+	tx.ID = tx.Hash()
+	tx.Sign(&from.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+	return tx
+}
+
+func isProtocolError(err error) bool {
+	_, ok := err.(*ProtocolError)
+	return ok
+}
+
+func TestMempoolAdd(t *testing.T) {
+	chain := newTestChain(t)
+	wallet, other := NewWallet(), NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := fundTestWallet(t, chain, wallet, "fund")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+
+	parent := newTestTx(wallet, cbTx, 0, *NewTxOutput(30, address), *NewTxOutput(19, address))
+	child := newTestTx(wallet, parent, 1, *NewTxOutput(19, address))
+	assert.Nil(t, pool.Add(*parent))
+	assert.Nil(t, pool.Add(*child))
+	assert.Equal(t, ErrMempoolDuplicate, pool.Add(*parent))
+	assert.Equal(t, 2, pool.Len())
+
+	conflict := newTestTx(wallet, cbTx, 0, *NewTxOutput(50, string(other.GetAddress())))
+	assert.Equal(t, ErrMempoolConflict, pool.Add(*conflict))
+	missing := &Transaction{nil, []TxInput{{parent.ID, 5, nil, wallet.PublicKey, SequenceFinal}}, []TxOutput{*NewTxOutput(1, address)}}
+	missing.ID = missing.Hash()
+	assert.Equal(t, ErrMissingInputs, pool.Add(*missing))
+	forged := newTestTx(wallet, parent, 0, *NewTxOutput(29, address))
+	forged.ID = []byte("victim")
+	assert.True(t, isProtocolError(pool.Add(*forged)))
+	overspend := newTestTx(wallet, parent, 0, *NewTxOutput(31, address))
+	assert.True(t, isProtocolError(pool.Add(*overspend)))
+	stolen := newTestTx(other, parent, 0, *NewTxOutput(30, address))
+	assert.True(t, isProtocolError(pool.Add(*stolen)))
+	assert.True(t, isProtocolError(pool.Add(*cbTx)))
+
+	entry := pool.entries[hex.EncodeToString(parent.ID)]
+	assert.Equal(t, 1, entry.Fee)
+	txs := pool.Txs()
+	assert.Equal(t, parent.ID, txs[0].ID)
+	assert.Equal(t, child.ID, txs[1].ID)
+}
+
+func TestMempoolEvictsLowestFeeRate(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cheap := newTestTx(wallet, fundTestWallet(t, chain, wallet, "a"), 0, *NewTxOutput(49, address))
+	rich := newTestTx(wallet, fundTestWallet(t, chain, wallet, "b"), 0, *NewTxOutput(40, address))
+	free := newTestTx(wallet, fundTestWallet(t, chain, wallet, "c"), 0, *NewTxOutput(50, address))
+	pool := NewMempool(chain, len(rich.Serialize())+10, time.Hour)
+
+	assert.Nil(t, pool.Add(*cheap))
+	assert.Nil(t, pool.Add(*rich))
+	_, ok := pool.Get(hex.EncodeToString(cheap.ID))
+	assert.False(t, ok)
+	assert.Equal(t, ErrMempoolFull, pool.Add(*free))
+	assert.Equal(t, 1, pool.Len())
+	assert.Equal(t, len(rich.Serialize()), pool.Bytes())
+}
+
+func TestMempoolExpire(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	parent := newTestTx(wallet, fundTestWallet(t, chain, wallet, "a"), 0, *NewTxOutput(50, address))
+	child := newTestTx(wallet, parent, 0, *NewTxOutput(50, address))
+	pool := NewMempool(chain, 1<<20, time.Hour)
+	assert.Nil(t, pool.Add(*parent))
+	assert.Nil(t, pool.Add(*child))
+
+	assert.Equal(t, 0, pool.Expire(time.Now()))
+	pool.entries[hex.EncodeToString(child.ID)].Time = time.Now()
+	pool.entries[hex.EncodeToString(parent.ID)].Time = time.Now().Add(-2 * time.Hour)
+	assert.Equal(t, 2, pool.Expire(time.Now()))
+	assert.Equal(t, 0, pool.Bytes())
+}
+
+func TestMempoolExpiresWithoutAdd(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	tx := newTestTx(wallet, fundTestWallet(t, chain, wallet, "a"), 0, *NewTxOutput(49, address))
+	pool := NewMempool(chain, 1<<20, time.Hour)
+	assert.Nil(t, pool.Add(*tx))
+	assert.Equal(t, 1, len(NewBlockTemplate(chain, pool, address, 1<<20).Transactions)-1)
+
+	pool.mu.Lock()
+	pool.entries[hex.EncodeToString(tx.ID)].Time = time.Now().Add(-2 * time.Hour)
+	pool.mu.Unlock()
+	assert.Empty(t, NewBlockTemplate(chain, pool, address, 1<<20).Transactions[1:])
+	assert.Equal(t, 0, pool.Len())
+}
+
+func TestMempoolFollowsChain(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	fork, height := chain.Tip()
+	pool := NewMempool(chain, 1<<20, time.Hour)
+	chain.Subscribe(pool.Update)
+
+	parent := newTestTx(wallet, cb1, 0, *NewTxOutput(50, address))
+	child := newTestTx(wallet, parent, 0, *NewTxOutput(50, address))
+	pending := newTestTx(wallet, cb2, 0, *NewTxOutput(50, address))
+	assert.Nil(t, pool.Add(*parent))
+	assert.Nil(t, pool.Add(*child))
+	assert.Nil(t, pool.Add(*pending))
+
+	conflict := newTestTx(wallet, cb2, 0, *NewTxOutput(49, address))
+	_, err := chain.MineBlock([]*Transaction{parent, conflict, NewCoinbaseTransaction(address, "c", 50)})
+	assert.Nil(t, err)
+	assert.Equal(t, []Transaction{*child}, pool.Txs())
+	utxos := UtxoStore{chain}
+	_, ok := utxos.FindOutput(cb1.ID, 0)
+	assert.False(t, ok)
+
+	b1 := NewBlock([]*Transaction{NewCoinbaseTransaction(address, "d", 50)}, fork, height+1, Difficulty)
+	chain.AddBlock(b1)
+	assert.Equal(t, 1, pool.Len())
+	b2 := NewBlock([]*Transaction{NewCoinbaseTransaction(address, "e", 50)}, b1.Hash, height+2, Difficulty)
+	chain.AddBlock(b2)
+	tip, _ := chain.Tip()
+	assert.Equal(t, b2.Hash, tip)
+	_, ok = utxos.FindOutput(cb1.ID, 0)
+	assert.True(t, ok)
+	txs := pool.Txs()
+	assert.Equal(t, 3, len(txs))
+	assert.Equal(t, parent.ID, txs[0].ID)
+	assert.Equal(t, child.ID, txs[1].ID)
+	assert.Equal(t, conflict.ID, txs[2].ID)
+}
+
+func TestMempoolSaveLoad(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	cb3 := fundTestWallet(t, chain, wallet, "c")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+	parent := newTestTx(wallet, cb1, 0, *NewTxOutput(50, address))
+	child := newTestTx(wallet, parent, 0, *NewTxOutput(50, address))
+	pending := newTestTx(wallet, cb2, 0, *NewTxOutput(50, address))
+	old := newTestTx(wallet, cb3, 0, *NewTxOutput(50, address))
+	for _, tx := range []*Transaction{parent, child, pending, old} {
+		assert.Nil(t, pool.Add(*tx))
+	}
+	pool.entries[hex.EncodeToString(old.ID)].Time = time.Now().Add(-2 * time.Hour)
+	accepted := pool.entries[hex.EncodeToString(child.ID)].Time
+	file := filepath.Join(t.TempDir(), "mempool.dat")
+	count, err := pool.Save(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+
+	conflict := newTestTx(wallet, cb2, 0, *NewTxOutput(49, address))
+	_, err = chain.MineBlock([]*Transaction{parent, conflict})
+	assert.Nil(t, err)
+	restored := NewMempool(chain, 1<<20, time.Hour)
+	loaded, dropped, err := restored.Load(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.Equal(t, 3, dropped)
+	assert.Equal(t, []Transaction{*child}, restored.Txs())
+	assert.True(t, accepted.Equal(restored.entries[hex.EncodeToString(child.ID)].Time))
+
+	_, _, err = restored.Load(filepath.Join(t.TempDir(), "missing.dat"))
+	assert.NotNil(t, err)
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	chain := newTestChain(t)
+	wallet, other := NewWallet(), NewWallet()
+	address := string(wallet.GetAddress())
+	cb1 := fundTestWallet(t, chain, wallet, "a")
+	cb2 := fundTestWallet(t, chain, wallet, "b")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+
+	final := newTestTx(wallet, cb1, 0, *NewTxOutput(49, address))
+	assert.Nil(t, pool.Add(*final))
+	assert.Equal(t, ErrMempoolConflict, pool.Add(*newTestRbfTx(wallet, cb1, 0, *NewTxOutput(40, address))))
+
+	original := newTestRbfTx(wallet, cb2, 0, *NewTxOutput(48, address))
+	child := newTestTx(wallet, original, 0, *NewTxOutput(47, address))
+	assert.Nil(t, pool.Add(*original))
+	assert.Nil(t, pool.Add(*child))
+	assert.Equal(t, ErrReplacementFee, pool.Add(*newTestRbfTx(wallet, cb2, 0, *NewTxOutput(48, string(other.GetAddress())))))
+	assert.Equal(t, ErrReplacementFee, pool.Add(*newTestRbfTx(wallet, cb2, 0, *NewTxOutput(47, address))))
+
+	replacement := newTestRbfTx(wallet, cb2, 0, *NewTxOutput(46, address))
+	assert.Nil(t, pool.Add(*replacement))
+	_, ok := pool.Get(hex.EncodeToString(original.ID))
+	assert.False(t, ok)
+	_, ok = pool.Get(hex.EncodeToString(child.ID))
+	assert.False(t, ok)
+	assert.Equal(t, 2, pool.Len())
+}
+
+func TestMempoolFullKeepsReplacedTransaction(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := fundTestWallet(t, chain, wallet, "a")
+	original := newTestRbfTx(wallet, cbTx, 0, *NewTxOutput(49, address))
+	rich := newTestTx(wallet, fundTestWallet(t, chain, wallet, "b"), 0, *NewTxOutput(40, address))
+	replacement := newTestRbfTx(wallet, cbTx, 0, *NewTxOutput(40, address), *NewTxOutput(5, address))
+	pool := NewMempool(chain, len(original.Serialize())+len(rich.Serialize())+5, time.Hour)
+	assert.Nil(t, pool.Add(*original))
+	assert.Nil(t, pool.Add(*rich))
+
+	assert.Equal(t, ErrMempoolFull, pool.Add(*replacement))
+	assert.Equal(t, 2, pool.Len())
+	_, ok := pool.Get(hex.EncodeToString(original.ID))
+	assert.True(t, ok)
+	assert.Equal(t, len(original.Serialize())+len(rich.Serialize()), pool.Bytes())
+	spender, ok := pool.spends[outpoint(original.Vin[0].Txid, 0)]
+	assert.True(t, ok)
+	assert.Equal(t, hex.EncodeToString(original.ID), spender)
+}
+
+func TestWalletBumpFee(t *testing.T) {
+	chain := newTestChain(t)
+	wallet, other := NewWallet(), NewWallet()
+	cbTx := fundTestWallet(t, chain, wallet, "a")
+	pool := NewMempool(chain, 1<<20, time.Hour)
+
+	final := newTestTx(wallet, cbTx, 0, *NewTxOutput(20, string(other.GetAddress())), *NewTxOutput(29, string(wallet.GetAddress())))
+	_, err := wallet.BumpFee(*final, 1)
+	assert.NotNil(t, err)
+
+	tx := newTestRbfTx(wallet, cbTx, 0, *NewTxOutput(20, string(other.GetAddress())), *NewTxOutput(29, string(wallet.GetAddress())))
+	assert.Nil(t, pool.Add(*tx))
+	_, err = other.BumpFee(*tx, 1)
+	assert.NotNil(t, err)
+	_, err = wallet.BumpFee(*tx, 30)
+	assert.NotNil(t, err)
+
+	replacement, err := wallet.BumpFee(*tx, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 24, replacement.Vout[1].Value)
+	assert.Nil(t, pool.Add(*replacement))
+	entry, ok := pool.Entry(hex.EncodeToString(replacement.ID))
+	assert.True(t, ok)
+	assert.Equal(t, 6, entry.Fee)
+	assert.Equal(t, 1, pool.Len())
+}
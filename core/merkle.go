@@ -15,7 +15,8 @@ type MerkleNode struct {
 }
 
 // NewMerkleTree creates tree from given data
-// On level 0 there is data, then non-leaf nodes are constructed level by level
+// On level 0 there is data, then non-leaf nodes are constructed level by level,
+// last node of level with odd number of nodes is duplicated
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 	if len(data)%2 != 0 {
@@ -25,7 +26,10 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, *node)
 	}
-	for i := 0; i < len(data)/2; i++ {
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
 		var level []MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
 			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
@@ -28,3 +28,25 @@ func TestMerkleTree(t *testing.T) {
 
 	assert.Equal(t, rootHash, treeHash, "Hash is correct")
 }
+
+func TestMerkleTreeOddLevels(t *testing.T) {
+	var data [][]byte
+	for i := 0; i < 5; i++ {
+		data = append(data, []byte(fmt.Sprintf("data%d", i)))
+	}
+	tree := NewMerkleTree(data)
+
+	var leaves []*MerkleNode
+	for _, datum := range append(data, data[4]) {
+		leaves = append(leaves, NewMerkleNode(nil, nil, datum))
+	}
+	mn1 := NewMerkleNode(leaves[0], leaves[1], nil)
+	mn2 := NewMerkleNode(leaves[2], leaves[3], nil)
+	mn3 := NewMerkleNode(leaves[4], leaves[5], nil)
+	mn4 := NewMerkleNode(mn1, mn2, nil)
+	mn5 := NewMerkleNode(mn3, mn3, nil)
+	root := NewMerkleNode(mn4, mn5, nil)
+
+	assert.Equal(t, root.Data, tree.Root.Data)
+	assert.NotPanics(t, func() { NewMerkleTree(append(data, data...)) })
+}
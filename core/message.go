@@ -0,0 +1,109 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// NetworkMagic identifies gochain main network messages on the wire
+const NetworkMagic = uint32(0xd9b4bef9)
+
+// MessageHeaderLength is length of header: magic, command, payload length and checksum
+const MessageHeaderLength = 4 + CommandLength + 4 + 4
+
+// MessageChecksumLength in bytes
+const MessageChecksumLength = 4
+
+// MaxMessagePayload is the maximum payload length of any message
+const MaxMessagePayload = 4 * 1024 * 1024
+
+// MaxControlPayload is the maximum payload length of small control commands
+const MaxControlPayload = 1024
+
+// maxPayloads holds maximum payload lengths of commands, hashes and addresses include gob overhead
+var maxPayloads = map[string]uint32{
+	"version":   4 * 1024,
+	"verack":    MaxControlPayload,
+	"getaddr":   MaxControlPayload,
+	"addr":      MaxAddrPerMessage * 128,
+	"ping":      MaxControlPayload,
+	"pong":      MaxControlPayload,
+	"getblocks": MaxControlPayload,
+	"getdata":   MaxControlPayload,
+	"inventory": MaxInventoryItems*40 + MaxControlPayload,
+}
+
+// MaxPayload gets maximum payload length accepted for command.
+// Block and transaction payloads are limited by network parameters with room for command fields,
+// unknown commands are limited to control payload length.
+func (params *ChainParams) MaxPayload(command string) uint32 {
+	switch command {
+	case "block":
+		return uint32(params.MaxBlockSize + MaxControlPayload)
+	case "transaction":
+		return uint32(params.MaxTxSize + MaxControlPayload)
+	}
+	max, ok := maxPayloads[command]
+	if !ok {
+		return MaxControlPayload
+	}
+	return max
+}
+
+// Message is single framed message exchanged between peers
+type Message struct {
+	Command string
+	Payload []byte
+}
+
+// WriteMessage writes framed message to writer
+func WriteMessage(w io.Writer, magic uint32, command string, payload []byte) (int, error) {
+	if len(command) > CommandLength {
+		return 0, fmt.Errorf("command too long: %s", command)
+	}
+	if len(payload) > MaxMessagePayload {
+		return 0, fmt.Errorf("payload too large: %d bytes", len(payload))
+	}
+	var buff bytes.Buffer
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, magic)
+	buff.Write(header)
+	buff.Write(ToBytes(command))
+	binary.LittleEndian.PutUint32(header, uint32(len(payload)))
+	buff.Write(header)
+	buff.Write(ShaChecksum(payload, MessageChecksumLength))
+	buff.Write(payload)
+	return w.Write(buff.Bytes())
+}
+
+// ReadMessage reads next framed message of network from reader.
+// Payload longer than allowed for its command is rejected before it is read.
+// Invalid framing is returned as misbehavior error.
+func ReadMessage(r io.Reader, params *ChainParams) (*Message, int, error) {
+	header := make([]byte, MessageHeaderLength)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, n, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != params.Magic {
+		return nil, n, misbehavior(ScoreMalformed, "invalid network magic: %x", header[0:4])
+	}
+	command := FromBytes(header[4 : 4+CommandLength])
+	length := binary.LittleEndian.Uint32(header[4+CommandLength : 8+CommandLength])
+	if length > params.MaxPayload(command) {
+		return nil, n, misbehavior(ScoreOversized, "payload too large for '%s': %d bytes", command, length)
+	}
+	checksum := header[8+CommandLength:]
+	payload := make([]byte, length)
+	read, err := io.ReadFull(r, payload)
+	n += read
+	if err != nil {
+		return nil, n, err
+	}
+	if !bytes.Equal(checksum, ShaChecksum(payload, MessageChecksumLength)) {
+		return nil, n, misbehavior(ScoreMalformed, "invalid checksum for '%s'", command)
+	}
+	return &Message{command, payload}, n, nil
+}
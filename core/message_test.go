@@ -0,0 +1,52 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteReadMessage(t *testing.T) {
+	var buff bytes.Buffer
+	_, err := WriteMessage(&buff, NetworkMagic, "version", []byte("payload1"))
+	assert.Nil(t, err)
+	_, err = WriteMessage(&buff, NetworkMagic, "getblocks", []byte{})
+	assert.Nil(t, err)
+	msg, n, err := ReadMessage(&buff, &MainParams)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageHeaderLength+8, n)
+	assert.Equal(t, "version", msg.Command)
+	assert.Equal(t, []byte("payload1"), msg.Payload)
+	msg, _, err = ReadMessage(&buff, &MainParams)
+	assert.Nil(t, err)
+	assert.Equal(t, "getblocks", msg.Command)
+	assert.Empty(t, msg.Payload)
+}
+
+func TestFailReadMessageWrongMagic(t *testing.T) {
+	var buff bytes.Buffer
+	WriteMessage(&buff, 0x01020304, "version", []byte("payload"))
+	_, _, err := ReadMessage(&buff, &MainParams)
+	assert.Contains(t, err.Error(), "invalid network magic")
+}
+
+func TestFailReadMessageBadChecksum(t *testing.T) {
+	var buff bytes.Buffer
+	WriteMessage(&buff, NetworkMagic, "block", []byte("payload"))
+	data := buff.Bytes()
+	data[len(data)-1] ^= 0xff
+	_, _, err := ReadMessage(bytes.NewReader(data), &MainParams)
+	assert.Contains(t, err.Error(), "invalid checksum")
+}
+
+func TestFailReadMessageOversized(t *testing.T) {
+	var buff bytes.Buffer
+	WriteMessage(&buff, NetworkMagic, "ping", make([]byte, MaxControlPayload+1))
+	n := buff.Len()
+	_, read, err := ReadMessage(&buff, &MainParams)
+	assert.Contains(t, err.Error(), "payload too large for 'ping'")
+	assert.Equal(t, ScoreOversized, err.(*ProtocolError).Score)
+	assert.Equal(t, MessageHeaderLength, read)
+	assert.Equal(t, n-MessageHeaderLength, buff.Len())
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errStaleBlock is returned when chain tip changed while block was mined
+var errStaleBlock = errors.New("chain tip changed while mining")
+
+// Miner mines blocks with pending transactions of node on its own goroutine.
+// Mining starts when transaction is accepted to mempool and at interval, with mineEmpty
+// blocks without transactions are mined at interval too.
+// Sealing is cancelled when chain tip changes and work restarts on the new tip.
+// With proof of authority node seals only blocks at heights when its authority is in turn.
+type Miner struct {
+	node      *Node
+	interval  time.Duration
+	threads   int
+	mineEmpty bool
+	wake      chan struct{}
+	cancel    context.CancelFunc
+	hashrate  float64
+	mu        sync.Mutex
+}
+
+// NewMiner creates miner paying rewards to miners address of node, proof of work runs on threads goroutines
+func NewMiner(node *Node, interval time.Duration, threads int, mineEmpty bool) *Miner {
+	miner := &Miner{
+		node:      node,
+		interval:  interval,
+		threads:   threads,
+		mineEmpty: mineEmpty,
+		wake:      make(chan struct{}, 1),
+	}
+	node.Chain.Subscribe(miner.chainUpdated)
+	return miner
+}
+
+// chainUpdated cancels work on stale tip
+func (miner *Miner) chainUpdated(update ChainUpdate) {
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+	if miner.cancel != nil {
+		miner.cancel()
+	}
+}
+
+// Hashrate gets hashes per second computed while mining the last block
+func (miner *Miner) Hashrate() float64 {
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+	return miner.hashrate
+}
+
+// Notify wakes miner up to mine pending transactions, it does not block
+func (miner *Miner) Notify() {
+	select {
+	case miner.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run mines blocks until quit is closed, closing quit cancels work in progress
+func (miner *Miner) Run(quit <-chan struct{}) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go func() {
+		<-quit
+		stop()
+	}()
+	var tick <-chan time.Time
+	if miner.interval > 0 {
+		ticker := time.NewTicker(miner.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		empty := false
+		select {
+		case <-quit:
+			return
+		case <-miner.wake:
+		case <-tick:
+			empty = miner.mineEmpty
+		}
+		for ctx.Err() == nil {
+			block, err := miner.mineBlock(ctx, empty)
+			if err == errStaleBlock {
+				fmt.Println("chain tip changed, restarting mining on new tip")
+				continue
+			}
+			if err == context.Canceled {
+				return
+			}
+			if err != nil {
+				fmt.Printf("error mining block: %s\n", err)
+				break
+			}
+			if block == nil {
+				break
+			}
+			empty = false
+		}
+	}
+}
+
+// mineBlock mines block from template on top of current tip and announces it to peers,
+// returns nil block when there are no pending transactions and empty block is not wanted
+// or when authority of node is not in turn
+func (miner *Miner) mineBlock(ctx context.Context, empty bool) (*Block, error) {
+	node := miner.node
+	work, cancel := context.WithCancel(ctx)
+	defer cancel()
+	miner.mu.Lock()
+	miner.cancel = cancel
+	miner.mu.Unlock()
+
+	template := NewBlockTemplate(node.Chain, node.Mempool, node.MinersAdds, node.Env.GetBlockMaxBytes())
+	if len(template.Transactions) == 1 && !empty {
+		return nil, nil
+	}
+	engine := node.Chain.Engine
+	pow, isPow := engine.(*ProofOfWork)
+	if isPow {
+		pow = NewProofOfWork(miner.threads)
+		engine = pow
+	}
+	block := template.NewBlock(template.Timestamp, 0)
+	err := engine.Prepare(node.Chain, block)
+	if err == errNotInTurn {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	template.Log()
+	start := time.Now()
+	err = engine.Seal(work, block)
+	hashrate := 0.0
+	if isPow {
+		hashrate = float64(pow.Hashes()) / time.Since(start).Seconds()
+	}
+	miner.mu.Lock()
+	miner.hashrate = hashrate
+	miner.mu.Unlock()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if work.Err() != nil {
+			return nil, errStaleBlock
+		}
+		return nil, err
+	}
+	err = node.SubmitBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("new block is mined! [height: %d] [hash: %x] [hashrate: %.0f H/s]\n", block.Height, block.Hash, hashrate)
+	return block, nil
+}
+
+// Generate mines n blocks on top of current tip paying rewards to address and returns their hashes.
+// It is meant for regtest network where proof of work is trivial.
+func (node *Node) Generate(n int, address string) ([][]byte, error) {
+	var hashes [][]byte
+	for len(hashes) < n {
+		template := NewBlockTemplate(node.Chain, node.Mempool, address, node.Env.GetBlockMaxBytes())
+		block := template.NewBlock(template.Timestamp, 0)
+		err := node.Chain.Engine.Prepare(node.Chain, block)
+		if err != nil {
+			return hashes, err
+		}
+		err = node.Chain.Engine.Seal(context.Background(), block)
+		if err != nil {
+			return hashes, err
+		}
+		err = node.SubmitBlock(block)
+		if err == errStaleBlock {
+			continue
+		}
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, block.Hash)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinerMinesPendingTransactions(t *testing.T) {
+	node := newTestNode(t, NewMemNetwork(1), t.TempDir(), "4501")
+	node.MinersAdds = string(NewWallet().GetAddress())
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := mineTestBlocks(t, node, 1, address)[0]
+	_, height := node.Chain.Tip()
+	miner := NewMiner(node, time.Hour, 2, false)
+	go miner.Run(node.quit)
+
+	parent := newTestTx(wallet, cbTx, 0, *NewTxOutput(49, address))
+	child := newTestTx(wallet, parent, 0, *NewTxOutput(47, address))
+	assert.Nil(t, node.Mempool.Add(*parent))
+	assert.Nil(t, node.Mempool.Add(*child))
+	miner.Notify()
+	waitFor(t, 10*time.Second, func() bool {
+		return node.Mempool.Len() == 0
+	})
+	tip, newHeight := node.Chain.Tip()
+	assert.Equal(t, height+1, newHeight)
+	block, err := node.Chain.GetBlock(tip)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(block.Transactions))
+	assert.Equal(t, 53, block.Transactions[2].Vout[0].Value)
+}
+
+func TestMinerMinesEmptyBlocks(t *testing.T) {
+	node := newTestNode(t, NewMemNetwork(1), t.TempDir(), "4502")
+	node.MinersAdds = string(NewWallet().GetAddress())
+	_, height := node.Chain.Tip()
+	go NewMiner(node, 10*time.Millisecond, 2, false).Run(node.quit)
+	time.Sleep(100 * time.Millisecond)
+	_, newHeight := node.Chain.Tip()
+	assert.Equal(t, height, newHeight)
+
+	go NewMiner(node, 10*time.Millisecond, 2, true).Run(node.quit)
+	waitFor(t, 10*time.Second, func() bool {
+		_, newHeight := node.Chain.Tip()
+		return newHeight >= height+2
+	})
+}
+
+func TestMinerHashrate(t *testing.T) {
+	node := newTestNode(t, NewMemNetwork(1), t.TempDir(), "4503")
+	node.MinersAdds = string(NewWallet().GetAddress())
+	miner := NewMiner(node, time.Hour, 2, true)
+	block, err := miner.mineBlock(context.Background(), true)
+	assert.Nil(t, err)
+	assert.True(t, block.ValidatePOW())
+	assert.True(t, miner.Hashrate() > 0)
+}
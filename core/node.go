@@ -2,13 +2,16 @@ package core
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Node struct
@@ -19,318 +22,811 @@ type Node struct {
 	Env        Config
 	Chain      *Blockchain
 	MinersAdds string
-	Mempool    map[string]Transaction
-	Transit    [][]byte
+	Peers      *PeerManager
+	Transport  Transport
+	Mempool    *Mempool
+	Miner      *Miner
+	nonce      uint64
+	transit    [][]byte
+	transLock  sync.Mutex
+	syncPeer   string
+	syncLock   sync.Mutex
+	handlers   sync.WaitGroup
+	running    int32
+	quit       chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
 }
 
 // NewNode creates new node
 func NewNode(env Config, port string, minersAddress string) *Node {
-	address := net.JoinHostPort(host, port)
-	wstore := NewWalletStore(env, port)
-	wstore.Load(env.GetWalletStoreFile(port))
-	wallet := wstore.CreateWallet()
-	coinbaseAddress := string(wallet.GetAddress())
-	chain := InitChain(env, coinbaseAddress, port)
-	return &Node{host, port, address, env, chain, minersAddress, make(map[string]Transaction), [][]byte{}}
-}
-
-// Start starts node at specific port server
-func (node *Node) Start() {
-	listen, err := net.Listen("tcp", node.Address)
-	defer listen.Close()
+	chain := InitChain(env, port)
+	node := NewNodeWithChain(env, port, minersAddress, chain)
+	if env.GetTransportSecurity() == TransportTLS {
+		key, err := LoadNodeKey(env.GetNodeKeyFile(port))
+		if err != nil {
+			panic(err)
+		}
+		tlsConfig, err := NewTLSConfig(key, env.GetAllowedPeerKeys())
+		if err != nil {
+			panic(err)
+		}
+		node.Transport = &TCPTransport{TLS: tlsConfig}
+		fingerprint, _ := KeyFingerprint(&key.PublicKey)
+		fmt.Printf("using tls transport with node key %s\n", fingerprint)
+	}
+	return node
+}
+
+// NewNodeWithChain creates node for initialized chain using plain tcp transport
+func NewNodeWithChain(env Config, port string, minersAddress string, chain *Blockchain) *Node {
+	host := env.GetHost()
+	node := &Node{
+		Host:       host,
+		Port:       port,
+		Address:    net.JoinHostPort(host, port),
+		Env:        env,
+		Chain:      chain,
+		MinersAdds: minersAddress,
+		Mempool:    NewMempool(chain, env.GetMempoolMaxBytes(), env.GetMempoolExpiry()),
+		transit:    [][]byte{},
+		Transport:  &TCPTransport{},
+		nonce:      newNonce(),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	node.Peers = NewPeerManager(node, env)
+	chain.Subscribe(node.Mempool.Update)
+	return node
+}
+
+// newNonce generates random nonce used to detect connections to self
+func newNonce() uint64 {
+	var buff [8]byte
+	_, err := rand.Read(buff[:])
 	if err != nil {
 		panic(err)
 	}
-	if node.Address != nodes[0] {
-		fmt.Printf("sending version to root node: %s\n", nodes[0])
-		node.SendVersionCommand(nodes[0], node.Chain, node.Env)
+	return binary.LittleEndian.Uint64(buff[:])
+}
+
+// Start starts node and serves peers until context is cancelled or node is stopped.
+// Node is shut down gracefully before Start returns.
+func (node *Node) Start(ctx context.Context) error {
+	atomic.StoreInt32(&node.running, 1)
+	defer close(node.done)
+	if len(node.MinersAdds) > 0 {
+		if _, err := node.Chain.Params.DecodeAddress(node.MinersAdds); err != nil {
+			return err
+		}
+		if poa, ok := node.Chain.Engine.(*ProofOfAuthority); ok {
+			if err := poa.Authorize(node.Chain.ws.GetWallet(node.MinersAdds)); err != nil {
+				return err
+			}
+		}
+		node.Miner = NewMiner(node, node.Env.GetMinerInterval(), node.Env.GetMinerThreads(), node.Env.GetMineEmptyBlocks())
+	}
+	listen, err := node.Transport.Listen(node.Address)
+	if err != nil {
+		return err
+	}
+	rpcListen, err := node.StartRPC()
+	if err != nil {
+		listen.Close()
+		return err
+	}
+	node.LoadMempool()
+	go node.saveMempoolPeriodically(node.Env.GetMempoolSaveInterval())
+	go node.expireMempoolPeriodically(MempoolExpireInterval)
+	if node.Miner != nil {
+		node.Miner.Notify()
+		node.handlers.Add(1)
+		go func() {
+			defer node.handlers.Done()
+			node.Miner.Run(node.quit)
+		}()
+	}
+	node.Peers.Start()
+	fmt.Printf("server listening on port: %s\n", node.Port)
+	go node.acceptConnections(listen)
+	select {
+	case <-ctx.Done():
+	case <-node.quit:
+	}
+	fmt.Printf("shutting down node %s\n", node.Port)
+	listen.Close()
+	rpcListen.Close()
+	return node.shutdown()
+}
+
+// Stop stops running node and waits until it is shut down
+func (node *Node) Stop() {
+	node.stopOnce.Do(func() {
+		close(node.quit)
+	})
+	if atomic.LoadInt32(&node.running) == 1 {
+		<-node.done
+	}
+}
+
+// stopping checks if node shutdown has started
+func (node *Node) stopping() bool {
+	select {
+	case <-node.quit:
+		return true
+	default:
+		return false
 	}
+}
+
+// acceptConnections accepts inbound peers until listener is closed
+func (node *Node) acceptConnections(listen net.Listener) {
 	for {
-		fmt.Printf("server listening on port: %s\n", node.Port)
 		conn, err := listen.Accept()
 		if err != nil {
-			panic(err)
+			if !node.stopping() {
+				fmt.Printf("error accepting connection: %s\n", err)
+				node.Stop()
+			}
+			return
+		}
+		peer, err := node.Peers.AddInbound(conn)
+		if err != nil {
+			fmt.Printf("rejecting connection from %s: %s\n", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
 		}
 		fmt.Printf("connection established on port: %s\n", node.Port)
-		go handleConnection(conn, node, node.Env)
+		node.startPeer(peer)
 	}
 }
 
-func handleConnection(conn net.Conn, node *Node, env Config) {
-	payload, err := ioutil.ReadAll(conn)
+// shutdown disconnects peers, waits for message handlers to finish,
+// flushes mempool and closes the chain
+func (node *Node) shutdown() error {
+	node.stopOnce.Do(func() {
+		close(node.quit)
+	})
+	node.Peers.Stop()
+	node.handlers.Wait()
+	err := node.SaveMempool()
 	if err != nil {
-		conn.Close()
-		panic(err)
+		fmt.Printf("error saving mempool: %s\n", err)
+	}
+	fmt.Printf("node %s stopped\n", node.Port)
+	return node.Chain.Close()
+}
+
+// SaveMempool writes pending transactions to node mempool file
+func (node *Node) SaveMempool() error {
+	count, err := node.Mempool.Save(node.Env.GetMempoolFile(node.Port))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("saved %d mempool transactions\n", count)
+	return nil
+}
+
+// LoadMempool reloads transactions saved by previous run of node
+func (node *Node) LoadMempool() {
+	loaded, dropped, err := node.Mempool.Load(node.Env.GetMempoolFile(node.Port))
+	if err != nil {
+		fmt.Printf("mempool not loaded: %s\n", err)
+		return
+	}
+	fmt.Printf("loaded %d mempool transactions, dropped %d expired, confirmed or conflicting\n", loaded, dropped)
+}
+
+// saveMempoolPeriodically saves mempool at interval until node stops, zero interval disables it
+func (node *Node) saveMempoolPeriodically(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := node.SaveMempool()
+			if err != nil {
+				fmt.Printf("error saving mempool: %s\n", err)
+			}
+		case <-node.quit:
+			return
+		}
+	}
+}
+
+// expireMempoolPeriodically drops expired transactions at interval until node stops,
+// so quiet mempool does not keep them until next transaction is added
+func (node *Node) expireMempoolPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			node.Mempool.Expire(time.Now())
+		case <-node.quit:
+			return
+		}
+	}
+}
+
+// startPeer starts reader and writer of peer registered by peer manager
+func (node *Node) startPeer(peer *Peer) {
+	if !peer.Inbound {
+		node.SendVersionCommand(peer)
+	}
+	go peer.writeHandler()
+	go handleConnection(peer, node, node.Env)
+}
+
+// handleConnection reads and dispatches messages until peer disconnects
+func handleConnection(peer *Peer, node *Node, env Config) {
+	defer node.handlers.Done()
+	defer func() {
+		peer.Close()
+		node.Peers.Remove(peer)
+		fmt.Printf("peer %s disconnected\n", peer.Addr())
+		node.stopSync(peer.Addr())
+	}()
+	err := peer.startHandshake(HandshakeTimeout)
+	if err != nil {
+		fmt.Printf("handshake with %s failed: %s\n", peer.Addr(), err)
+		return
+	}
+	for {
+		msg, err := peer.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("error reading from %s: %s\n", peer.Addr(), err)
+			}
+			if perr, ok := err.(*ProtocolError); ok {
+				node.Peers.Misbehaving(peer, perr.Score, perr.Reason)
+			}
+			return
+		}
+		fmt.Printf("received command: '%s' from %s\n", msg.Command, peer.Addr())
+		if !peer.HandshakeComplete() && msg.Command != "version" && msg.Command != "verack" {
+			fmt.Printf("received '%s' before handshake from %s, disconnecting\n", msg.Command, peer.Addr())
+			node.Peers.Misbehaving(peer, ScoreProtocolViolation, "message before handshake")
+			return
+		}
+		err = node.handleMessage(peer, msg, env)
+		if err != nil {
+			fmt.Printf("error processing '%s' from %s: %s\n", msg.Command, peer.Addr(), err)
+			if perr, ok := err.(*ProtocolError); ok {
+				node.Peers.Misbehaving(peer, perr.Score, perr.Reason)
+			}
+		}
+	}
+}
+
+// handleMessage dispatches message to its command handler
+func (node *Node) handleMessage(peer *Peer, msg *Message, env Config) error {
+	if !peer.AllowRequest(msg.Command) {
+		return misbehavior(ScoreRateLimited, "rate limit exceeded for '%s', request dropped", msg.Command)
 	}
-	command := FromBytes(payload[:CommandLength])
-	fmt.Printf("received command: '%s' \n", command)
-	switch command {
+	switch msg.Command {
 	case "version":
-		node.ReceiveVersionCommand(payload, env)
+		return node.ReceiveVersionCommand(peer, msg.Payload, env)
+	case "verack":
+		return node.ReceiveVerackCommand(peer, msg.Payload, env)
+	case "getaddr":
+		return node.ReceiveGetAddrCommand(peer, msg.Payload, env)
+	case "addr":
+		return node.ReceiveAddrCommand(peer, msg.Payload, env)
+	case "ping":
+		return node.ReceivePingCommand(peer, msg.Payload, env)
+	case "pong":
+		return node.ReceivePongCommand(peer, msg.Payload, env)
 	case "getblocks":
-		node.ReceiveGetBlocksCommand(payload, env)
+		return node.ReceiveGetBlocksCommand(peer, msg.Payload, env)
 	case "inventory":
-		node.ReceiveInventoryCommand(payload, env)
+		return node.ReceiveInventoryCommand(peer, msg.Payload, env)
 	case "getdata":
-		node.ReceiveGetDataCommand(payload, env)
+		return node.ReceiveGetDataCommand(peer, msg.Payload, env)
 	case "block":
-		node.ReceiveBlockCommand(payload, env)
+		return node.ReceiveBlockCommand(peer, msg.Payload, env)
 	case "transaction":
-		node.ReceiveTransactionCommand(payload, env)
+		return node.ReceiveTransactionCommand(peer, msg.Payload, env)
 	default:
-		panic("unknown command")
+		return misbehavior(ScoreUnknownCommand, "unknown command '%s'", msg.Command)
 	}
-	conn.Close()
 }
 
-// SendData sends data to address
-func (node *Node) SendData(address string, data []byte) {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		fmt.Printf("node @ %s is not available\n", address)
-		var updatedNodes []string
-		for _, node := range nodes {
-			if node != address {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-		nodes = updatedNodes
-		return
+// SendVersionCommand starts handshake by sending version to peer
+func (node *Node) SendVersionCommand(peer *Peer) {
+	_, height := node.Chain.Tip()
+	versionCommand := VersionCommand{
+		Version:   ProtocolVersion,
+		Services:  node.netAddress().Services,
+		UserAgent: UserAgent,
+		Origin:    node.Address,
+		Height:    height,
+		Nonce:     node.nonce,
+		Timestamp: time.Now().Unix(),
 	}
-	defer conn.Close()
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
+	peer.versionSent = true
+	peer.Send("version", EncodeData(versionCommand))
+}
+
+// SendVerackCommand acknowledges received version
+func (node *Node) SendVerackCommand(peer *Peer) {
+	peer.Send("verack", []byte{})
+}
+
+// SendGetAddrCommand requests known addresses from peer
+func (node *Node) SendGetAddrCommand(peer *Peer) {
+	peer.Send("getaddr", []byte{})
+}
+
+// SendAddrCommand advertises addresses to peer
+func (node *Node) SendAddrCommand(peer *Peer, addrs []NetAddress) {
+	if len(addrs) == 0 {
+		return
 	}
+	peer.Send("addr", EncodeData(AddrCommand{node.Address, addrs}))
 }
 
-// SendVersionCommand handles send version command
-func (node *Node) SendVersionCommand(address string, bc *Blockchain, env Config) {
-	bestHeight := GetBestHeight(bc.db, env)
-	versionCommand := VersionCommand{ProtocolVersion, node.Address, bestHeight}
-	payload := EncodeData(versionCommand)
-	fmt.Printf(" version command: %x \t %x\n", versionCommand, payload)
-	request := append(ToBytes("version"), payload...)
-	node.SendData(address, request)
+// netAddress gets advertised address of this node
+func (node *Node) netAddress() NetAddress {
+	services := ServiceNodeNetwork
+	if len(node.MinersAdds) > 0 {
+		services |= ServiceNodeMining
+	}
+	return NetAddress{node.Address, services, time.Now().Unix()}
 }
 
-// SendGetBlocksCommand handles sending get block command
-func (node *Node) SendGetBlocksCommand(address string) {
-	payload := EncodeData(GetBlocksCommand{node.Address})
-	request := append(ToBytes("getblocks"), payload...)
-	node.SendData(address, request)
+// SendGetBlocksCommand requests block hashes from peer
+func (node *Node) SendGetBlocksCommand(peer *Peer) {
+	peer.Send("getblocks", []byte{})
 }
 
-// SendGetDataCommand sends getdata command
-func (node *Node) SendGetDataCommand(address, kind string, id []byte) {
-	payload := EncodeData(GetDataCommand{node.Address, kind, id})
-	request := append(ToBytes("getdata"), payload...)
-	node.SendData(address, request)
+// SendGetDataCommand requests block or transaction from peer
+func (node *Node) SendGetDataCommand(peer *Peer, kind string, id []byte) {
+	peer.Send("getdata", EncodeData(GetDataCommand{kind, id}))
 }
 
-// SendBlockCommand sends block
-func (node *Node) SendBlockCommand(address string, b *Block) {
-	data := BlockCommand{node.Address, b.Serialize()}
-	payload := EncodeData(data)
-	request := append(ToBytes("block"), payload...)
-	node.SendData(address, request)
+// SendBlockCommand sends block to peer
+func (node *Node) SendBlockCommand(peer *Peer, b *Block) {
+	peer.Send("block", EncodeData(BlockCommand{b.Serialize()}))
 }
 
-// SendTransaction sends transaction
-func (node *Node) SendTransaction(address string, t *Transaction) {
-	data := TransactionCommand{node.Address, t.Serialize()}
-	payload := EncodeData(data)
-	request := append(ToBytes("transaction"), payload...)
-	node.SendData(address, request)
+// SendTransaction sends transaction to peer
+func (node *Node) SendTransaction(peer *Peer, t *Transaction) {
+	peer.Send("transaction", EncodeData(TransactionCommand{t.Serialize()}))
 }
 
-// SendInventory sends inventory of specific type
-func (node *Node) SendInventory(address, kind string, items [][]byte) {
-	inventory := InventoryCommand{node.Address, kind, items}
-	payload := EncodeData(inventory)
-	request := append(ToBytes("inventory"), payload...)
-	node.SendData(address, request)
+// SendInventory sends inventory of specific type to peer
+func (node *Node) SendInventory(peer *Peer, kind string, items [][]byte) {
+	peer.Send("inventory", EncodeData(InventoryCommand{kind, items}))
 }
 
 // ReceiveVersionCommand handles receiving version command
-func (node *Node) ReceiveVersionCommand(request []byte, env Config) {
-	var buff bytes.Buffer
+func (node *Node) ReceiveVersionCommand(peer *Peer, request []byte, env Config) error {
 	var data VersionCommand
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&data)
+	err := decodePayload(request, &data)
 	if err != nil {
-		log.Panic(err)
-	}
-	fmt.Printf("processing version command from %s\n", data.Origin)
-	localHeight := GetBestHeight(node.Chain.db, env)
-	remoteHeight := data.Height
-	fmt.Printf("local vs remote height ::: %d ~ %d\n", localHeight, remoteHeight)
-	if localHeight < remoteHeight {
-		node.SendGetBlocksCommand(data.Origin)
-	} else if localHeight > remoteHeight {
-		node.SendVersionCommand(data.Origin, node.Chain, env)
-	}
-	if !KnownNode(data.Origin) {
-		fmt.Printf("registering unknown node: %s \n", data.Origin)
-		nodes = append(nodes, data.Origin)
-	}
-}
-
-// ReceiveGetBlocksCommand sends inventory on received blocks command
-func (node *Node) ReceiveGetBlocksCommand(request []byte, env Config) {
-	var buff bytes.Buffer
-	var command GetBlocksCommand
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&command)
+		return err
+	}
+	fmt.Printf("processing version command from %s [version:%d] [agent:%s]\n", data.Origin, data.Version, data.UserAgent)
+	if peer.versionRecv {
+		return misbehavior(ScoreProtocolViolation, "duplicate version")
+	}
+	if data.Nonce == node.nonce {
+		fmt.Printf("connected to self via %s, disconnecting\n", peer.Addr())
+		peer.Close()
+		return nil
+	}
+	if data.Version < MinProtocolVersion {
+		fmt.Printf("peer %s uses incompatible protocol version %d, disconnecting\n", data.Origin, data.Version)
+		peer.Close()
+		return nil
+	}
+	if node.Peers.Bans.IsBanned(peer.RemoteAddr()) {
+		fmt.Printf("peer %s is banned, disconnecting\n", peer.RemoteAddr())
+		peer.Close()
+		return nil
+	}
+	peer.versionRecv = true
+	peer.Version = data.Version
+	if peer.Version > ProtocolVersion {
+		peer.Version = ProtocolVersion
+	}
+	peer.Services = data.Services
+	peer.UserAgent = data.UserAgent
+	peer.StartHeight = data.Height
+	if !node.Peers.Register(data.Origin, peer, data.Nonce) {
+		fmt.Printf("already connected to %s, closing duplicate connection\n", data.Origin)
+		peer.Close()
+		return nil
+	}
+	node.Chain.Time.AddSample(peer.RemoteHost(), data.Timestamp)
+	if !peer.versionSent {
+		node.SendVersionCommand(peer)
+	}
+	node.SendVerackCommand(peer)
+	return nil
+}
+
+// ReceiveVerackCommand completes handshake with peer
+func (node *Node) ReceiveVerackCommand(peer *Peer, request []byte, env Config) error {
+	if !peer.versionSent || !peer.versionRecv {
+		peer.Close()
+		return misbehavior(ScoreProtocolViolation, "verack before version")
+	}
+	if peer.HandshakeComplete() {
+		return misbehavior(ScoreProtocolViolation, "duplicate verack")
+	}
+	peer.completeHandshake()
+	peer.conn.SetReadDeadline(time.Time{})
+	fmt.Printf("handshake with %s complete [version:%d] [services:%d]\n", peer.Addr(), peer.Version, peer.Services)
+	if peer.Inbound {
+		node.Peers.Book.Add(peer.Addr(), peer.Services, time.Now().Unix(), peer.RemoteAddr())
+	} else {
+		node.Peers.Book.Good(peer.Addr())
+		node.SendGetAddrCommand(peer)
+	}
+	node.SendAddrCommand(peer, []NetAddress{node.netAddress()})
+	peer.Ping(PingTimeout)
+	go peer.pingHandler(PingInterval, PingTimeout)
+	return nil
+}
+
+// ReceivePingCommand answers ping with pong
+func (node *Node) ReceivePingCommand(peer *Peer, request []byte, env Config) error {
+	var payload PingCommand
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer.Send("pong", EncodeData(PongCommand{payload.Nonce}))
+	return nil
+}
+
+// ReceivePongCommand records peer latency and starts sync once latency of new peer is known
+func (node *Node) ReceivePongCommand(peer *Peer, request []byte, env Config) error {
+	var payload PongCommand
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	if !peer.Pong(payload.Nonce) {
+		return fmt.Errorf("unexpected pong nonce %d", payload.Nonce)
+	}
+	node.startSync()
+	return nil
+}
+
+// startSync requests blocks from the lowest latency peer that is ahead of local chain,
+// unless blocks are already being downloaded
+func (node *Node) startSync() {
+	node.syncLock.Lock()
+	defer node.syncLock.Unlock()
+	if node.syncPeer != "" {
+		return
+	}
+	_, localHeight := node.Chain.Tip()
+	peer := node.Peers.SyncPeer(localHeight)
+	if peer == nil {
+		return
+	}
+	fmt.Printf("syncing from %s [latency:%s] local vs remote height ::: %d ~ %d\n",
+		peer.Addr(), peer.Latency(), localHeight, peer.StartHeight)
+	node.syncPeer = peer.Addr()
+	node.SendGetBlocksCommand(peer)
+}
+
+// stopSync clears sync peer and restarts sync from another peer if needed
+func (node *Node) stopSync(address string) {
+	node.syncLock.Lock()
+	if node.syncPeer != address {
+		node.syncLock.Unlock()
+		return
+	}
+	node.syncPeer = ""
+	node.syncLock.Unlock()
+	node.startSync()
+}
+
+// ReceiveGetAddrCommand responds with addresses from address book
+func (node *Node) ReceiveGetAddrCommand(peer *Peer, request []byte, env Config) error {
+	var addrs []NetAddress
+	for _, ka := range node.Peers.Book.Addresses(MaxAddrPerMessage) {
+		if ka.Addr != peer.Addr() {
+			addrs = append(addrs, NetAddress{ka.Addr, ka.Services, ka.LastSeen})
+		}
+	}
+	node.SendAddrCommand(peer, addrs)
+	return nil
+}
+
+// ReceiveAddrCommand adds advertised addresses to address book and relays new ones
+func (node *Node) ReceiveAddrCommand(peer *Peer, request []byte, env Config) error {
+	var payload AddrCommand
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if len(payload.Addresses) > MaxAddrPerMessage {
+		return misbehavior(ScoreMalformed, "too many addresses: %d", len(payload.Addresses))
+	}
+	fmt.Printf("received %d addresses from %s\n", len(payload.Addresses), peer.Addr())
+	var fresh []NetAddress
+	for _, addr := range payload.Addresses {
+		if addr.Addr == node.Address {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr.Addr); err != nil {
+			return misbehavior(ScoreMalformed, "invalid address %s", addr.Addr)
+		}
+		if node.Peers.Book.Add(addr.Addr, addr.Services, addr.Timestamp, peer.RemoteAddr()) {
+			fresh = append(fresh, addr)
+		}
+	}
+	if len(fresh) == 0 || len(payload.Addresses) > MaxAddrRelay {
+		return nil
 	}
+	relayed := 0
+	for _, p := range node.Peers.Peers() {
+		if p != peer && p.HandshakeComplete() && relayed < AddrRelayPeers {
+			node.SendAddrCommand(p, fresh)
+			relayed++
+		}
+	}
+	return nil
+}
+
+// ReceiveGetBlocksCommand answers getblocks with inventory of all block hashes
+func (node *Node) ReceiveGetBlocksCommand(peer *Peer, request []byte, env Config) error {
 	blocks := node.Chain.GetBlockHashes()
-	node.SendInventory(command.Origin, "block", blocks)
+	for start := 0; start < len(blocks); start += MaxInventoryItems {
+		end := start + MaxInventoryItems
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+		node.SendInventory(peer, "block", blocks[start:end])
+	}
+	return nil
 }
 
 // ReceiveInventoryCommand handles inventory command
-func (node *Node) ReceiveInventoryCommand(request []byte, env Config) {
-	var buff bytes.Buffer
+func (node *Node) ReceiveInventoryCommand(peer *Peer, request []byte, env Config) error {
 	var payload InventoryCommand
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if len(payload.Data) == 0 {
+		return misbehavior(ScoreMalformed, "empty inventory")
+	}
+	if len(payload.Data) > MaxInventoryItems {
+		return misbehavior(ScoreOversized, "inventory with %d items", len(payload.Data))
 	}
 	fmt.Printf("recevied inventory with %d %s\n", len(payload.Data), payload.Type)
-	if payload.Type == "block" {
-		node.Transit = payload.Data
-		blockHash := payload.Data[0]
-		node.SendGetDataCommand(payload.Origin, "block", blockHash)
-		newInTransit := [][]byte{}
-		for _, b := range node.Transit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+	switch payload.Type {
+	case "block":
+		var missing [][]byte
+		for _, hash := range payload.Data {
+			if !node.Chain.HasBlock(hash) {
+				missing = append(missing, hash)
 			}
 		}
-		fmt.Printf("new in transit: %d \n", len(newInTransit))
-		node.Transit = newInTransit
-	}
-	if payload.Type == "transaction" {
-		txID := payload.Data[0]
-		if node.Mempool[hex.EncodeToString(txID)].ID == nil {
-			node.SendGetDataCommand(payload.Origin, "transaction", txID)
+		if len(missing) == 0 {
+			node.stopSync(peer.Addr())
+			return nil
 		}
+		blockHash, start := node.queueBlocks(missing)
+		if start {
+			node.SendGetDataCommand(peer, "block", blockHash)
+		}
+		fmt.Printf("new in transit: %d \n", node.transitLen())
+	case "transaction":
+		for _, txID := range payload.Data {
+			if _, ok := node.Mempool.Get(hex.EncodeToString(txID)); !ok {
+				node.SendGetDataCommand(peer, "transaction", txID)
+			}
+		}
+	default:
+		return misbehavior(ScoreMalformed, "unknown inventory type %s", payload.Type)
 	}
+	return nil
 }
 
 // ReceiveBlockCommand processes block command
-func (node *Node) ReceiveBlockCommand(request []byte, env Config) {
-	var buff bytes.Buffer
+func (node *Node) ReceiveBlockCommand(peer *Peer, request []byte, env Config) error {
 	var payload BlockCommand
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	blockData := payload.Block
-	block := Deserialize(blockData)
-	node.Chain.AddBlock(block)
-	fmt.Printf("added new block [height: %x] [hash: %x] \n", block.Height, block.Hash)
-	if len(node.Transit) > 0 {
-		blockHash := node.Transit[0]
-		fmt.Printf("fetching next node in transit from %s [hash: %x] \n", payload.Origin, blockHash)
-		node.SendGetDataCommand(payload.Origin, "block", blockHash)
-		node.Transit = node.Transit[1:]
-		fmt.Printf("new transit size %d \n", len(node.Transit))
+	block, err := node.Chain.Params.DecodeBlock(payload.Block)
+	if err != nil {
+		return misbehavior(ScoreMalformed, "malformed block: %s", err)
+	}
+	if len(block.PrevBlockHash) > 0 && !node.Chain.HasBlock(block.PrevBlockHash) {
+		fmt.Printf("parent of block %x is unknown, requesting blocks from %s\n", block.Hash, peer.Addr())
+		node.SendGetBlocksCommand(peer)
 	} else {
-		UTXOSet := UtxoStore{node.Chain}
-		UTXOSet.Reindex()
+		err = node.Chain.ValidateBlock(block)
+		if err != nil {
+			node.clearTransit()
+			node.stopSync(peer.Addr())
+			return err
+		}
+		node.Chain.AddBlock(block)
+		fmt.Printf("added new block [height: %x] [hash: %x] \n", block.Height, block.Hash)
+	}
+	if blockHash, ok := node.nextBlock(); ok {
+		fmt.Printf("fetching next node in transit from %s [hash: %x] \n", peer.Addr(), blockHash)
+		node.SendGetDataCommand(peer, "block", blockHash)
+		fmt.Printf("new transit size %d \n", node.transitLen())
+	} else {
+		node.announceTip(peer)
+		node.stopSync(peer.Addr())
+	}
+	return nil
+}
+
+// announceTip sends hash of chain tip to peers except the one blocks came from.
+// Peers that already have the block ignore it, peers missing its parents request them.
+func (node *Node) announceTip(origin *Peer) {
+	tip, _ := node.Chain.Tip()
+	node.broadcastInventory("block", tip, origin)
+}
+
+// broadcastInventory announces item to peers with completed handshake except origin.
+// Announcement is dropped for peer with full send queue, the peer catches up with next getblocks or inventory.
+func (node *Node) broadcastInventory(kind string, id []byte, origin *Peer) {
+	payload := EncodeData(InventoryCommand{kind, [][]byte{id}})
+	for _, peer := range node.Peers.Peers() {
+		if peer != origin && peer.HandshakeComplete() && !peer.TrySend("inventory", payload) {
+			fmt.Printf("send queue of %s is full, dropping %s inventory %x\n", peer.RemoteAddr(), kind, id)
+		}
 	}
 }
 
 // ReceiveTransactionCommand receives transaction command
-func (node *Node) ReceiveTransactionCommand(request []byte, env Config) {
-	var buff bytes.Buffer
+func (node *Node) ReceiveTransactionCommand(peer *Peer, request []byte, env Config) error {
 	var payload TransactionCommand
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
-	}
-	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	node.Mempool[hex.EncodeToString(tx.ID)] = tx
-	if node.Address == nodes[0] {
-		for _, n := range nodes {
-			if n != node.Address && n != payload.Origin {
-				node.SendInventory(n, "transaction", [][]byte{tx.ID})
-			}
-		}
-	} else {
-		if len(node.Mempool) >= 2 && len(node.MinersAdds) > 0 {
-		MineTransactions:
-			var txs []*Transaction
-			for id := range node.Mempool {
-				tx := node.Mempool[id]
-				if node.Chain.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
-
-			if len(txs) == 0 {
-				fmt.Println("all transactions are invalid! waiting for new ones ...")
-				return
-			}
+		return err
+	}
+	tx, err := node.Chain.Params.DecodeTransaction(payload.Transaction)
+	if err != nil {
+		return misbehavior(ScoreMalformed, "malformed transaction: %s", err)
+	}
+	err = node.Mempool.Add(tx)
+	if err == ErrMempoolDuplicate {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return node.transactionAccepted(tx, peer)
+}
 
-			cbTx := NewCoinbaseTransaction(node.MinersAdds, "", node.Env.GetBlockReward())
-			txs = append(txs, cbTx)
+// SubmitTransaction adds transaction created by local wallet to mempool and announces it to peers
+func (node *Node) SubmitTransaction(tx Transaction) error {
+	err := node.Mempool.Add(tx)
+	if err != nil {
+		return err
+	}
+	return node.transactionAccepted(tx, nil)
+}
 
-			newBlock, err := node.Chain.MineBlock(txs)
-			if err != nil {
-				panic(err)
-			}
-			UTXOSet := UtxoStore{node.Chain}
-			UTXOSet.Reindex()
+// SubmitBlock validates block mined on top of current tip, adds it to chain and announces it to peers
+func (node *Node) SubmitBlock(block *Block) error {
+	if tip, _ := node.Chain.Tip(); !bytes.Equal(tip, block.PrevBlockHash) {
+		return errStaleBlock
+	}
+	err := node.Chain.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+	node.Chain.AddBlock(block)
+	node.broadcastInventory("block", block.Hash, nil)
+	return nil
+}
 
-			fmt.Println("new block is mined!")
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(node.Mempool, txID)
-			}
-			for _, n := range nodes {
-				if n != node.Address {
-					node.SendInventory(n, "block", [][]byte{newBlock.Hash})
-				}
-			}
-			if len(node.Mempool) > 0 {
-				goto MineTransactions
-			}
-		}
+// transactionAccepted announces transaction accepted to mempool to peers except its origin
+// and wakes up miner
+func (node *Node) transactionAccepted(tx Transaction, origin *Peer) error {
+	node.broadcastInventory("transaction", tx.ID, origin)
+	if node.Miner != nil {
+		node.Miner.Notify()
 	}
+	return nil
 }
 
 // ReceiveGetDataCommand handles getdata command
-func (node *Node) ReceiveGetDataCommand(request []byte, env Config) {
-	var buff bytes.Buffer
+func (node *Node) ReceiveGetDataCommand(peer *Peer, request []byte, env Config) error {
 	var payload GetDataCommand
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	if payload.Type == "block" {
+	switch payload.Type {
+	case "block":
 		block, err := node.Chain.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return nil
+		}
+		node.SendBlockCommand(peer, &block)
+	case "transaction":
+		tx, ok := node.Mempool.Get(hex.EncodeToString(payload.ID))
+		if !ok {
+			return nil
 		}
-		node.SendBlockCommand(payload.Orign, &block)
+		node.SendTransaction(peer, &tx)
+	default:
+		return misbehavior(ScoreMalformed, "unknown getdata type %s", payload.Type)
 	}
-	if payload.Type == "transaction" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := node.Mempool[txID]
-		node.SendTransaction(payload.Orign, &tx)
+	return nil
+}
+
+// queueBlocks adds hashes to blocks in transit.
+// When no download was in progress first hash is taken out and returned to be fetched.
+func (node *Node) queueBlocks(hashes [][]byte) ([]byte, bool) {
+	node.transLock.Lock()
+	defer node.transLock.Unlock()
+	fetching := len(node.transit) > 0
+	for _, hash := range hashes {
+		if !containsHash(node.transit, hash) {
+			node.transit = append(node.transit, hash)
+		}
+	}
+	if fetching || len(node.transit) == 0 {
+		return nil, false
+	}
+	hash := node.transit[0]
+	node.transit = node.transit[1:]
+	return hash, true
+}
+
+// nextBlock takes out next block hash to download
+func (node *Node) nextBlock() ([]byte, bool) {
+	node.transLock.Lock()
+	defer node.transLock.Unlock()
+	if len(node.transit) == 0 {
+		return nil, false
+	}
+	hash := node.transit[0]
+	node.transit = node.transit[1:]
+	return hash, true
+}
+
+// clearTransit drops blocks waiting for download, next sync peer announces them again
+func (node *Node) clearTransit() {
+	node.transLock.Lock()
+	defer node.transLock.Unlock()
+	node.transit = nil
+}
+
+// transitLen gets number of blocks waiting for download
+func (node *Node) transitLen() int {
+	node.transLock.Lock()
+	defer node.transLock.Unlock()
+	return len(node.transit)
+}
+
+// containsHash checks if hash is in list
+func containsHash(hashes [][]byte, hash []byte) bool {
+	for _, h := range hashes {
+		if bytes.Equal(h, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodePayload decodes command payload, malformed payload is peer misbehavior
+func decodePayload(request []byte, payload interface{}) error {
+	err := DecodeData(request, payload)
+	if err != nil {
+		return misbehavior(ScoreMalformed, "malformed payload: %s", err)
 	}
+	return nil
 }
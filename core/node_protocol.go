@@ -8,61 +8,99 @@ import (
 )
 
 // ProtocolVersion number
-const ProtocolVersion = 1.0
+const ProtocolVersion = 2
 
-// CommandLength in bytes
-const CommandLength = 12
+// MinProtocolVersion is the oldest protocol version peers may use
+const MinProtocolVersion = 2
+
+// UserAgent identifies node software in version command
+const UserAgent = "/gochain:0.2.0/"
+
+// Service flags advertised in version command
+const (
+	// ServiceNodeNetwork serves full blocks
+	ServiceNodeNetwork uint64 = 1 << iota
+	// ServiceNodeMining mines new blocks
+	ServiceNodeMining
+)
+
+// MaxAddrRelay is the largest addr command whose new addresses are relayed further
+const MaxAddrRelay = 10
+
+// AddrRelayPeers is number of peers new addresses are relayed to
+const AddrRelayPeers = 2
+
+// Misbehavior scores added to peer for invalid data
+const (
+	ScoreMalformed          = 20
+	ScoreUnknownCommand     = 10
+	ScoreProtocolViolation  = 10
+	ScoreInvalidTransaction = 10
+	ScoreInvalidBlock       = 100
+	ScoreRateLimited        = 1
+	ScoreOversized          = 20
+)
 
-var host = "localhost"
+// MaxInventoryItems is the maximum number of hashes in single inventory command
+const MaxInventoryItems = 500
 
-var nodes = []string{"localhost:3000"}
+// CommandLength in bytes
+const CommandLength = 12
 
 // VersionCommand struct
 type VersionCommand struct {
-	Version int
-	Origin  string
-	Height  int
-}
-
-// GetBlocksCommand struct
-type GetBlocksCommand struct {
-	Origin string
+	Version   int
+	Services  uint64
+	UserAgent string
+	Origin    string
+	Height    int
+	Nonce     uint64
+	Timestamp int64
 }
 
 // GetDataCommand struct
 type GetDataCommand struct {
-	Orign string
-	Type  string
-	ID    []byte
+	Type string
+	ID   []byte
 }
 
 // InventoryCommand struct
 type InventoryCommand struct {
-	Origin string
-	Type   string
-	Data   [][]byte
+	Type string
+	Data [][]byte
 }
 
 // BlockCommand struct
 type BlockCommand struct {
-	Origin string
-	Block  []byte
+	Block []byte
 }
 
 // TransactionCommand struct
 type TransactionCommand struct {
-	Origin      string
 	Transaction []byte
 }
 
-// KnownNode checks if node is known
-func KnownNode(address string) bool {
-	for _, node := range nodes {
-		if node == address {
-			return true
-		}
-	}
-	return false
+// NetAddress is peer address advertised in addr command
+type NetAddress struct {
+	Addr      string
+	Services  uint64
+	Timestamp int64
+}
+
+// AddrCommand struct
+type AddrCommand struct {
+	Origin    string
+	Addresses []NetAddress
+}
+
+// PingCommand struct
+type PingCommand struct {
+	Nonce uint64
+}
+
+// PongCommand struct
+type PongCommand struct {
+	Nonce uint64
 }
 
 // ToBytes converts command to bytes
@@ -90,6 +128,26 @@ func ExtractCommand(payload []byte) []byte {
 	return payload[:CommandLength]
 }
 
+// ProtocolError is error caused by invalid data received from peer
+type ProtocolError struct {
+	Score  int
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Reason
+}
+
+// misbehavior makes protocol error with misbehavior score
+func misbehavior(score int, format string, args ...interface{}) error {
+	return &ProtocolError{score, fmt.Sprintf(format, args...)}
+}
+
+// DecodeData decodes gob encoded data
+func DecodeData(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
 // EncodeData encodes data using gob
 func EncodeData(data interface{}) []byte {
 	var buff bytes.Buffer
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// Transport security modes
+const (
+	TransportPlain = "plain"
+	TransportTLS   = "tls"
+)
+
+// TLSHandshakeTimeout is how long TLS handshake may take
+const TLSHandshakeTimeout = 10 * time.Second
+
+// LoadNodeKey loads node static key from PEM file, new key is generated and saved when file does not exist
+func LoadNodeKey(file string) (*ecdsa.PrivateKey, error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		content = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		return key, ioutil.WriteFile(file, content, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in node key file %s", file)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// KeyFingerprint gets hex encoded sha256 of DER encoded public key
+func KeyFingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewTLSConfig creates mutual TLS config with self-signed certificate of node key.
+// Peers must present certificate signed by its own key, when allowedKeys are given
+// only peers with listed key fingerprints are accepted.
+func NewTLSConfig(key *ecdsa.PrivateKey, allowedKeys []string) (*tls.Config, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "gochain node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool)
+	for _, k := range allowedKeys {
+		allowed[k] = true
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		ClientAuth:   tls.RequireAnyClientCert,
+		MinVersion:   tls.VersionTLS13,
+		// certificates are self-signed, peer is authenticated by its key in VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeerKey(rawCerts, allowed)
+		},
+	}, nil
+}
+
+// verifyPeerKey checks peer certificate is self-signed and its key is allowed
+func verifyPeerKey(rawCerts [][]byte, allowed map[string]bool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("peer sent no certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	err = cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+	if err != nil {
+		return fmt.Errorf("peer certificate is not signed by its key: %s", err)
+	}
+	fingerprint, err := KeyFingerprint(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	if len(allowed) > 0 && !allowed[fingerprint] {
+		return fmt.Errorf("peer key %s is not allowed", fingerprint)
+	}
+	return nil
+}
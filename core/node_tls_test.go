@@ -0,0 +1,73 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadNodeKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "node_key.pem")
+	key, err := LoadNodeKey(file)
+	assert.Nil(t, err)
+	loaded, err := LoadNodeKey(file)
+	assert.Nil(t, err)
+	assert.Equal(t, key.D, loaded.D)
+}
+
+func TestTLSPinnedPeerKeys(t *testing.T) {
+	serverKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	allowedKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serverFingerprint, _ := KeyFingerprint(&serverKey.PublicKey)
+	allowedFingerprint, _ := KeyFingerprint(&allowedKey.PublicKey)
+
+	serverConfig, err := NewTLSConfig(serverKey, []string{allowedFingerprint})
+	assert.Nil(t, err)
+	listen, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	assert.Nil(t, err)
+	defer listen.Close()
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				msg, _, err := ReadMessage(conn, &MainParams)
+				if err == nil {
+					WriteMessage(conn, NetworkMagic, "pong", msg.Payload)
+				}
+			}()
+		}
+	}()
+
+	allowedConfig, _ := NewTLSConfig(allowedKey, []string{serverFingerprint})
+	conn, err := tls.Dial("tcp", listen.Addr().String(), allowedConfig)
+	assert.Nil(t, err)
+	_, err = WriteMessage(conn, NetworkMagic, "ping", []byte("nonce"))
+	assert.Nil(t, err)
+	msg, _, err := ReadMessage(conn, &MainParams)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", msg.Command)
+	assert.Equal(t, []byte("nonce"), msg.Payload)
+	conn.Close()
+
+	otherConfig, _ := NewTLSConfig(otherKey, nil)
+	conn, err = tls.Dial("tcp", listen.Addr().String(), otherConfig)
+	if err == nil {
+		_, _, err = ReadMessage(conn, &MainParams)
+		conn.Close()
+	}
+	assert.NotNil(t, err)
+
+	wrongServerConfig, _ := NewTLSConfig(allowedKey, []string{allowedFingerprint})
+	_, err = tls.Dial("tcp", listen.Addr().String(), wrongServerConfig)
+	assert.Contains(t, err.Error(), "is not allowed")
+}
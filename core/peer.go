@@ -0,0 +1,316 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PeerSendQueueSize is number of messages buffered for a peer writer
+const PeerSendQueueSize = 64
+
+// HandshakeTimeout is how long peer may take to complete version handshake before it is disconnected
+const HandshakeTimeout = 30 * time.Second
+
+// PingInterval is how often peers are pinged
+const PingInterval = 30 * time.Second
+
+// PingTimeout is how long to wait for pong before disconnecting peer
+const PingTimeout = 60 * time.Second
+
+// Peer is long-lived connection to another node
+type Peer struct {
+	addr        string
+	addrLock    sync.RWMutex
+	Inbound     bool
+	Version     int
+	Services    uint64
+	UserAgent   string
+	StartHeight int
+	ConnectedAt time.Time
+	params      *ChainParams
+	conn        net.Conn
+	send        chan *Message
+	handshake   chan *Message
+	ready       chan struct{}
+	quit        chan struct{}
+	closeOnce   sync.Once
+	versionSent bool
+	versionRecv bool
+	stats       peerStats
+	pingLock    sync.Mutex
+	pingNonce   uint64
+	pingSent    time.Time
+	limiters    map[string]*TokenBucket
+}
+
+// peerStats holds traffic counters updated by peer reader and writer
+type peerStats struct {
+	bytesSent uint64
+	bytesRecv uint64
+	msgsSent  uint64
+	msgsRecv  uint64
+	lastSend  int64
+	lastRecv  int64
+	latency   int64
+	banScore  int64
+}
+
+// PeerInfo describes connected peer
+type PeerInfo struct {
+	Addr             string
+	Inbound          bool
+	Version          int
+	Services         uint64
+	UserAgent        string
+	StartHeight      int
+	ConnectedAt      int64
+	BytesSent        uint64
+	BytesReceived    uint64
+	MessagesSent     uint64
+	MessagesReceived uint64
+	LastSend         int64
+	LastReceive      int64
+	Latency          time.Duration
+	BanScore         int
+}
+
+// NewPeer wraps connection into peer exchanging messages of network
+func NewPeer(conn net.Conn, addr string, inbound bool, params *ChainParams) *Peer {
+	return &Peer{
+		addr:        addr,
+		Inbound:     inbound,
+		ConnectedAt: time.Now(),
+		params:      params,
+		conn:        conn,
+		send:        make(chan *Message, PeerSendQueueSize),
+		handshake:   make(chan *Message, 2),
+		ready:       make(chan struct{}),
+		quit:        make(chan struct{}),
+		limiters:    newRequestLimiters(),
+	}
+}
+
+// Addr gets address of peer, it is remote address until peer registers its advertised address
+func (peer *Peer) Addr() string {
+	peer.addrLock.RLock()
+	defer peer.addrLock.RUnlock()
+	return peer.addr
+}
+
+func (peer *Peer) setAddr(address string) {
+	peer.addrLock.Lock()
+	defer peer.addrLock.Unlock()
+	peer.addr = address
+}
+
+// AllowRequest checks rate limit of command, commands without limit are always allowed
+func (peer *Peer) AllowRequest(command string) bool {
+	limiter := peer.limiters[command]
+	return limiter == nil || limiter.Allow()
+}
+
+// Send queues message for the peer writer.
+// Messages other than version and verack are held back until handshake is complete.
+func (peer *Peer) Send(command string, payload []byte) {
+	queue := peer.send
+	if command == "version" || command == "verack" {
+		queue = peer.handshake
+	}
+	select {
+	case queue <- &Message{command, payload}:
+	case <-peer.quit:
+	}
+}
+
+// TrySend queues message for the peer writer without waiting, message is dropped when send queue is full.
+// Broadcasts use it so that one stalled peer does not block handling of messages of another peer.
+func (peer *Peer) TrySend(command string, payload []byte) bool {
+	select {
+	case peer.send <- &Message{command, payload}:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadMessage reads next message from peer connection
+func (peer *Peer) ReadMessage() (*Message, error) {
+	msg, n, err := ReadMessage(peer.conn, peer.params)
+	atomic.AddUint64(&peer.stats.bytesRecv, uint64(n))
+	if err == nil {
+		atomic.AddUint64(&peer.stats.msgsRecv, 1)
+		atomic.StoreInt64(&peer.stats.lastRecv, time.Now().Unix())
+	}
+	return msg, err
+}
+
+// Info gets peer description with traffic statistics.
+// Version details are reported only after handshake is complete.
+func (peer *Peer) Info() PeerInfo {
+	info := PeerInfo{
+		Addr:             peer.Addr(),
+		Inbound:          peer.Inbound,
+		ConnectedAt:      peer.ConnectedAt.Unix(),
+		BytesSent:        atomic.LoadUint64(&peer.stats.bytesSent),
+		BytesReceived:    atomic.LoadUint64(&peer.stats.bytesRecv),
+		MessagesSent:     atomic.LoadUint64(&peer.stats.msgsSent),
+		MessagesReceived: atomic.LoadUint64(&peer.stats.msgsRecv),
+		LastSend:         atomic.LoadInt64(&peer.stats.lastSend),
+		LastReceive:      atomic.LoadInt64(&peer.stats.lastRecv),
+		Latency:          peer.Latency(),
+		BanScore:         int(atomic.LoadInt64(&peer.stats.banScore)),
+	}
+	if peer.HandshakeComplete() {
+		info.Version = peer.Version
+		info.Services = peer.Services
+		info.UserAgent = peer.UserAgent
+		info.StartHeight = peer.StartHeight
+	}
+	return info
+}
+
+// addBanScore increases misbehavior score and returns new total
+func (peer *Peer) addBanScore(score int) int {
+	return int(atomic.AddInt64(&peer.stats.banScore, int64(score)))
+}
+
+// Latency gets last measured ping round-trip time, zero when not measured yet
+func (peer *Peer) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&peer.stats.latency))
+}
+
+// Ping sends ping unless one is outstanding.
+// Returns false when outstanding ping has timed out.
+func (peer *Peer) Ping(timeout time.Duration) bool {
+	peer.pingLock.Lock()
+	defer peer.pingLock.Unlock()
+	if peer.pingNonce != 0 {
+		return time.Since(peer.pingSent) < timeout
+	}
+	peer.pingNonce = newNonce()
+	peer.pingSent = time.Now()
+	peer.Send("ping", EncodeData(PingCommand{peer.pingNonce}))
+	return true
+}
+
+// Pong records round-trip latency if nonce matches outstanding ping
+func (peer *Peer) Pong(nonce uint64) bool {
+	peer.pingLock.Lock()
+	defer peer.pingLock.Unlock()
+	if nonce == 0 || nonce != peer.pingNonce {
+		return false
+	}
+	atomic.StoreInt64(&peer.stats.latency, int64(time.Since(peer.pingSent)))
+	peer.pingNonce = 0
+	return true
+}
+
+// pingHandler pings peer periodically and disconnects it when pong does not arrive in time
+func (peer *Peer) pingHandler(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !peer.Ping(timeout) {
+				fmt.Printf("ping timeout for %s, disconnecting\n", peer.RemoteAddr())
+				peer.Close()
+				return
+			}
+		case <-peer.quit:
+			return
+		}
+	}
+}
+
+// HandshakeComplete checks if version and verack were exchanged
+func (peer *Peer) HandshakeComplete() bool {
+	select {
+	case <-peer.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// startHandshake sets deadline for handshake to complete.
+// TLS handshake of inbound connection is completed first, limited by its own timeout.
+func (peer *Peer) startHandshake(timeout time.Duration) error {
+	if conn, ok := peer.conn.(*tls.Conn); ok && peer.Inbound {
+		conn.SetDeadline(time.Now().Add(TLSHandshakeTimeout))
+		err := conn.Handshake()
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Time{})
+	}
+	return peer.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// completeHandshake marks handshake as complete and releases queued messages
+func (peer *Peer) completeHandshake() {
+	close(peer.ready)
+}
+
+// Close closes peer connection and stops its writer
+func (peer *Peer) Close() {
+	peer.closeOnce.Do(func() {
+		close(peer.quit)
+		peer.conn.Close()
+	})
+}
+
+// Done is closed once peer is disconnected
+func (peer *Peer) Done() <-chan struct{} {
+	return peer.quit
+}
+
+// RemoteAddr gets the remote network address of peer connection
+func (peer *Peer) RemoteAddr() string {
+	return peer.conn.RemoteAddr().String()
+}
+
+// RemoteHost gets host of peer connection remote address
+func (peer *Peer) RemoteHost() string {
+	return hostOf(peer.RemoteAddr())
+}
+
+// hostOf gets host part of address, address without port is host itself
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+func (peer *Peer) writeHandler() {
+	for {
+		var msg *Message
+		select {
+		case msg = <-peer.handshake:
+		case <-peer.quit:
+			return
+		case <-peer.ready:
+			select {
+			case msg = <-peer.handshake:
+			case msg = <-peer.send:
+			case <-peer.quit:
+				return
+			}
+		}
+		n, err := WriteMessage(peer.conn, peer.params.Magic, msg.Command, msg.Payload)
+		atomic.AddUint64(&peer.stats.bytesSent, uint64(n))
+		if err != nil {
+			fmt.Printf("error writing '%s' to %s: %s\n", msg.Command, peer.RemoteAddr(), err)
+			peer.Close()
+			return
+		}
+		atomic.AddUint64(&peer.stats.msgsSent, 1)
+		atomic.StoreInt64(&peer.stats.lastSend, time.Now().Unix())
+	}
+}
@@ -0,0 +1,414 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectInterval is how often peer manager checks outbound connections
+const ConnectInterval = 5 * time.Second
+
+// MinDialBackoff is delay before first redial of unreachable peer
+const MinDialBackoff = time.Second
+
+// MaxDialBackoff is the longest delay between redials of unreachable peer
+const MaxDialBackoff = 5 * time.Minute
+
+// PeerManager keeps track of connected peers and maintains outbound connections.
+// It is safe for concurrent use.
+type PeerManager struct {
+	Book            *AddrBook
+	Bans            *BanList
+	node            *Node
+	seeds           []string
+	targetOutbound  int
+	maxInbound      int
+	banThreshold    int
+	banDuration     time.Duration
+	connectInterval time.Duration
+	peers           map[string]*Peer
+	dialing         map[string]bool
+	backoff         map[string]*dialBackoff
+	mu              sync.RWMutex
+	quit            chan struct{}
+}
+
+// dialBackoff tracks failed dials to address
+type dialBackoff struct {
+	failures int
+	next     time.Time
+}
+
+// NewPeerManager creates peer manager for node
+func NewPeerManager(node *Node, config Config) *PeerManager {
+	return &PeerManager{
+		Book:            NewAddrBook(config, node.Port),
+		Bans:            NewBanList(config, node.Port),
+		node:            node,
+		seeds:           config.GetSeedPeers(),
+		targetOutbound:  config.GetMaxOutboundPeers(),
+		maxInbound:      config.GetMaxInboundPeers(),
+		banThreshold:    config.GetBanThreshold(),
+		banDuration:     config.GetBanDuration(),
+		connectInterval: ConnectInterval,
+		peers:           make(map[string]*Peer),
+		dialing:         make(map[string]bool),
+		backoff:         make(map[string]*dialBackoff),
+		quit:            make(chan struct{}),
+	}
+}
+
+// Start connects to known or seed peers and keeps outbound connections at target
+func (pm *PeerManager) Start() {
+	err := pm.Book.Load()
+	if err != nil {
+		fmt.Printf("address book not loaded: %s\n", err)
+	}
+	fmt.Printf("address book loaded with %d addresses\n", pm.Book.Len())
+	err = pm.Bans.Load()
+	if err != nil {
+		fmt.Printf("ban list not loaded: %s\n", err)
+	}
+	pm.maintainOutbound()
+	go func() {
+		ticker := time.NewTicker(pm.connectInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pm.maintainOutbound()
+				err := pm.Book.Save()
+				if err != nil {
+					fmt.Printf("error saving address book: %s\n", err)
+				}
+			case <-pm.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops maintaining connections, disconnects all peers and saves address book
+func (pm *PeerManager) Stop() {
+	pm.mu.Lock()
+	select {
+	case <-pm.quit:
+	default:
+		close(pm.quit)
+	}
+	pm.mu.Unlock()
+	for _, peer := range pm.Peers() {
+		peer.Close()
+	}
+	err := pm.Book.Save()
+	if err != nil {
+		fmt.Printf("error saving address book: %s\n", err)
+	}
+}
+
+// stopped checks if peer manager was stopped
+func (pm *PeerManager) stopped() bool {
+	select {
+	case <-pm.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Connect gets connected peer for address or dials new outbound connection.
+// Dial in progress counts towards outbound connection target, which is never exceeded.
+func (pm *PeerManager) Connect(address string) (*Peer, error) {
+	pm.mu.Lock()
+	if peer := pm.peers[address]; peer != nil {
+		pm.mu.Unlock()
+		return peer, nil
+	}
+	if pm.stopped() {
+		pm.mu.Unlock()
+		return nil, fmt.Errorf("peer manager stopped")
+	}
+	if pm.dialing[address] {
+		pm.mu.Unlock()
+		return nil, fmt.Errorf("already dialing %s", address)
+	}
+	if pm.countLocked(false)+len(pm.dialing) >= pm.targetOutbound {
+		pm.mu.Unlock()
+		return nil, fmt.Errorf("outbound connection limit %d reached", pm.targetOutbound)
+	}
+	pm.dialing[address] = true
+	pm.mu.Unlock()
+	pm.Book.Attempt(address)
+	conn, err := pm.node.Transport.Dial(address)
+	pm.mu.Lock()
+	delete(pm.dialing, address)
+	pm.mu.Unlock()
+	if err != nil {
+		pm.dialFailed(address)
+		return nil, err
+	}
+	pm.mu.Lock()
+	if peer := pm.peers[address]; peer != nil {
+		pm.mu.Unlock()
+		conn.Close()
+		return peer, nil
+	}
+	if pm.stopped() {
+		pm.mu.Unlock()
+		conn.Close()
+		return nil, fmt.Errorf("peer manager stopped")
+	}
+	delete(pm.backoff, address)
+	peer := NewPeer(conn, address, false, pm.node.Chain.Params)
+	pm.peers[address] = peer
+	pm.node.handlers.Add(1)
+	pm.mu.Unlock()
+	pm.node.startPeer(peer)
+	return peer, nil
+}
+
+// AddInbound registers accepted connection unless its host is banned or inbound limit is reached.
+// Peer handler is counted under lock so node shutdown waits for it.
+func (pm *PeerManager) AddInbound(conn net.Conn) (*Peer, error) {
+	if host := hostOf(conn.RemoteAddr().String()); pm.Bans.IsBanned(host) {
+		return nil, fmt.Errorf("host %s is banned", host)
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stopped() {
+		return nil, fmt.Errorf("peer manager stopped")
+	}
+	if pm.countLocked(true) >= pm.maxInbound {
+		return nil, fmt.Errorf("inbound connection limit %d reached", pm.maxInbound)
+	}
+	peer := NewPeer(conn, conn.RemoteAddr().String(), true, pm.node.Chain.Params)
+	pm.peers[peer.Addr()] = peer
+	pm.node.handlers.Add(1)
+	return peer, nil
+}
+
+// Register registers peer under its advertised address.
+// Peer already connected under the address is kept, unless both are simultaneous inbound
+// and outbound connections with the same remote host: then both nodes keep connection
+// dialed by node with lower nonce, so it resolves the same way on both sides.
+// Inbound peer advertising address of outbound peer on another host can't replace it.
+// Returns false when peer is duplicate and should be disconnected.
+func (pm *PeerManager) Register(address string, peer *Peer, remoteNonce uint64) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	existing := pm.peers[address]
+	if existing != nil && existing != peer {
+		if existing.Inbound == peer.Inbound || existing.RemoteHost() != peer.RemoteHost() {
+			return false
+		}
+		keepOutbound := pm.node.nonce < remoteNonce
+		if existing.Inbound != keepOutbound {
+			return false
+		}
+		existing.Close()
+	}
+	if pm.peers[peer.Addr()] == peer {
+		delete(pm.peers, peer.Addr())
+	}
+	peer.setAddr(address)
+	pm.peers[address] = peer
+	return true
+}
+
+// Remove removes disconnected peer
+func (pm *PeerManager) Remove(peer *Peer) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.peers[peer.Addr()] == peer {
+		delete(pm.peers, peer.Addr())
+	}
+}
+
+// Misbehaving adds misbehavior score to peer, remote host of peer is banned and disconnected
+// once its score reaches ban threshold. Advertised address of peer is not trusted for bans.
+func (pm *PeerManager) Misbehaving(peer *Peer, score int, reason string) {
+	total := peer.addBanScore(score)
+	fmt.Printf("peer %s misbehaving [score:+%d=%d] %s\n", peer.Addr(), score, total, reason)
+	if total >= pm.banThreshold {
+		pm.Ban(peer.RemoteHost(), pm.banDuration)
+	}
+}
+
+// Ban bans address, disconnects matching peers and persists ban list
+func (pm *PeerManager) Ban(address string, duration time.Duration) {
+	fmt.Printf("banning %s for %s\n", address, duration)
+	pm.Bans.Ban(address, duration)
+	err := pm.Bans.Save()
+	if err != nil {
+		fmt.Printf("error saving ban list: %s\n", err)
+	}
+	for _, peer := range pm.Peers() {
+		if pm.Bans.IsBanned(peer.RemoteAddr()) || !peer.Inbound && pm.Bans.IsBanned(peer.Addr()) {
+			peer.Close()
+		}
+	}
+}
+
+// Unban removes ban of address and persists ban list
+func (pm *PeerManager) Unban(address string) bool {
+	ok := pm.Bans.Unban(address)
+	err := pm.Bans.Save()
+	if err != nil {
+		fmt.Printf("error saving ban list: %s\n", err)
+	}
+	return ok
+}
+
+// Peer gets connected peer by address
+func (pm *PeerManager) Peer(address string) *Peer {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.peers[address]
+}
+
+// Peers gets all connected peers
+func (pm *PeerManager) Peers() []*Peer {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	peers := make([]*Peer, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Addresses gets addresses of all connected peers
+func (pm *PeerManager) Addresses() []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	addresses := make([]string, 0, len(pm.peers))
+	for address := range pm.peers {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// PeerInfos gets description of all connected peers
+func (pm *PeerManager) PeerInfos() []PeerInfo {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	infos := make([]PeerInfo, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		infos = append(infos, peer.Info())
+	}
+	return infos
+}
+
+// SyncPeer gets peer to download blocks from: among peers with height above
+// local height the one with lowest latency is chosen
+func (pm *PeerManager) SyncPeer(localHeight int) *Peer {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	var best *Peer
+	for _, peer := range pm.peers {
+		if !peer.HandshakeComplete() || peer.StartHeight <= localHeight {
+			continue
+		}
+		if best == nil || fasterThan(peer, best) {
+			best = peer
+		}
+	}
+	return best
+}
+
+// fasterThan compares peer latencies, peers without measurement are slowest
+func fasterThan(a, b *Peer) bool {
+	la, lb := a.Latency(), b.Latency()
+	if la == 0 {
+		return false
+	}
+	return lb == 0 || la < lb
+}
+
+// OutboundCount gets number of outbound peers
+func (pm *PeerManager) OutboundCount() int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.countLocked(false)
+}
+
+func (pm *PeerManager) countLocked(inbound bool) int {
+	count := 0
+	for _, peer := range pm.peers {
+		if peer.Inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// candidates gets addresses worth dialing now.
+// Addresses from address book are preferred, seeds are used as fallback.
+func (pm *PeerManager) candidates() []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	now := time.Now()
+	exclude := func(address string) bool {
+		if address == pm.node.Address || pm.peers[address] != nil || pm.dialing[address] || pm.Bans.IsBanned(address) {
+			return true
+		}
+		b := pm.backoff[address]
+		return b != nil && now.Before(b.next)
+	}
+	addresses := pm.Book.Select(pm.targetOutbound, exclude)
+	for _, seed := range pm.seeds {
+		if !exclude(seed) && !contains(addresses, seed) {
+			addresses = append(addresses, seed)
+		}
+	}
+	return addresses
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// maintainOutbound dials candidates in background until outbound target is reached,
+// so unreachable address does not hold up other dials
+func (pm *PeerManager) maintainOutbound() {
+	pm.mu.RLock()
+	free := pm.targetOutbound - pm.countLocked(false) - len(pm.dialing)
+	pm.mu.RUnlock()
+	for _, address := range pm.candidates() {
+		if free <= 0 {
+			return
+		}
+		free--
+		fmt.Printf("connecting to peer: %s\n", address)
+		go func(address string) {
+			_, err := pm.Connect(address)
+			if err != nil {
+				fmt.Printf("node @ %s is not available\n", address)
+			}
+		}(address)
+	}
+}
+
+// dialFailed schedules next dial with exponential backoff
+func (pm *PeerManager) dialFailed(address string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	b := pm.backoff[address]
+	if b == nil {
+		b = &dialBackoff{}
+		pm.backoff[address] = b
+	}
+	delay := MinDialBackoff << uint(b.failures)
+	if delay > MaxDialBackoff || delay <= 0 {
+		delay = MaxDialBackoff
+	}
+	b.failures++
+	b.next = time.Now().Add(delay)
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerManagerSkipsSelf(t *testing.T) {
+	node := &Node{Address: "localhost:3000"}
+	pm := NewPeerManager(node, &EnvConfig{})
+	pm.seeds = []string{"localhost:3000", "localhost:3001"}
+	assert.Equal(t, []string{"localhost:3001"}, pm.candidates())
+}
+
+func TestPeerManagerPrefersAddressBook(t *testing.T) {
+	node := &Node{Address: "localhost:3000"}
+	pm := NewPeerManager(node, &EnvConfig{})
+	pm.seeds = []string{"localhost:3001"}
+	pm.Book.Add("localhost:3005", ServiceNodeNetwork, 1, "localhost:3005")
+	pm.Book.Good("localhost:3004")
+	assert.Equal(t, []string{"localhost:3004", "localhost:3005", "localhost:3001"}, pm.candidates())
+}
+
+func TestPeerManagerDialBackoff(t *testing.T) {
+	node := &Node{Address: "localhost:3000", Transport: NewMemNetwork(1).Transport("localhost:3000")}
+	pm := NewPeerManager(node, &EnvConfig{})
+	pm.seeds = []string{"localhost:1"}
+	_, err := pm.Connect("localhost:1")
+	assert.NotNil(t, err)
+	assert.Empty(t, pm.candidates())
+	assert.Equal(t, 1, pm.backoff["localhost:1"].failures)
+	pm.dialFailed("localhost:1")
+	assert.Equal(t, 2, pm.backoff["localhost:1"].failures)
+	assert.Equal(t, 0, pm.OutboundCount())
+}
+
+func TestPeerManagerSyncPeerByLatency(t *testing.T) {
+	node := &Node{Address: "localhost:3000"}
+	pm := NewPeerManager(node, &EnvConfig{})
+	slow := &Peer{addr: "localhost:3001", StartHeight: 5, ready: make(chan struct{})}
+	fast := &Peer{addr: "localhost:3002", StartHeight: 5, ready: make(chan struct{})}
+	short := &Peer{addr: "localhost:3003", StartHeight: 1, ready: make(chan struct{})}
+	for _, p := range []*Peer{slow, fast, short} {
+		p.completeHandshake()
+		pm.peers[p.Addr()] = p
+	}
+	slow.stats.latency = int64(80 * time.Millisecond)
+	fast.stats.latency = int64(5 * time.Millisecond)
+	assert.Equal(t, fast, pm.SyncPeer(2))
+	assert.Nil(t, pm.SyncPeer(5))
+}
+
+func TestPeerManagerMisbehavingBans(t *testing.T) {
+	node := &Node{Address: "localhost:3000"}
+	pm := NewPeerManager(node, &EnvConfig{})
+	pm.Bans.file = filepath.Join(t.TempDir(), "bans.dat")
+	conn, other := net.Pipe()
+	defer other.Close()
+	remote := &remoteConn{conn, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50001}}
+	peer := NewPeer(remote, "10.0.0.1:50001", true, &MainParams)
+	pm.peers[peer.Addr()] = peer
+	assert.True(t, pm.Register("10.0.0.2:3001", peer, 1))
+	pm.Misbehaving(peer, ScoreMalformed, "test")
+	assert.Equal(t, ScoreMalformed, peer.Info().BanScore)
+	assert.False(t, pm.Bans.IsBanned("10.0.0.1"))
+	pm.Misbehaving(peer, ScoreInvalidBlock, "test")
+	assert.True(t, pm.Bans.IsBanned("10.0.0.1"))
+	assert.False(t, pm.Bans.IsBanned("10.0.0.2:3001"))
+	<-peer.Done()
+	pm.Remove(peer)
+	pm.seeds = []string{"10.0.0.1:3001", "10.0.0.2:3001"}
+	assert.Equal(t, []string{"10.0.0.2:3001"}, pm.candidates())
+
+	inConn, inOther := net.Pipe()
+	defer inOther.Close()
+	_, err := pm.AddInbound(&remoteConn{inConn, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50002}})
+	assert.Contains(t, err.Error(), "banned")
+}
+
+func TestPeerManagerRegisterDuplicate(t *testing.T) {
+	node := &Node{Address: "localhost:3000", nonce: 5}
+	pm := NewPeerManager(node, &EnvConfig{})
+	outConn, outOther := net.Pipe()
+	defer outOther.Close()
+	inConn, inOther := net.Pipe()
+	defer inOther.Close()
+	outbound := NewPeer(outConn, "localhost:3001", false, &MainParams)
+	pm.peers[outbound.Addr()] = outbound
+	inbound := NewPeer(inConn, "localhost:50001", true, &MainParams)
+	pm.peers[inbound.Addr()] = inbound
+
+	assert.False(t, pm.Register("localhost:3001", inbound, 9))
+	assert.Equal(t, outbound, pm.Peer("localhost:3001"))
+	assert.True(t, pm.Register("localhost:3001", inbound, 1))
+	assert.Equal(t, inbound, pm.Peer("localhost:3001"))
+	assert.Nil(t, pm.Peer("localhost:50001"))
+	<-outbound.Done()
+}
+
+// remoteConn is connection with fixed remote address
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestPeerManagerRegisterRejectsOtherHost(t *testing.T) {
+	node := &Node{Address: "localhost:3000", nonce: 5}
+	pm := NewPeerManager(node, &EnvConfig{})
+	outConn, outOther := net.Pipe()
+	defer outOther.Close()
+	inConn, inOther := net.Pipe()
+	defer inOther.Close()
+	outbound := NewPeer(&remoteConn{outConn, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3001}}, "10.0.0.1:3001", false, &MainParams)
+	pm.peers[outbound.Addr()] = outbound
+	inbound := NewPeer(&remoteConn{inConn, &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 50001}}, "10.0.0.2:50001", true, &MainParams)
+	pm.peers[inbound.Addr()] = inbound
+
+	assert.False(t, pm.Register("10.0.0.1:3001", inbound, 1))
+	assert.Equal(t, outbound, pm.Peer("10.0.0.1:3001"))
+	assert.Equal(t, inbound, pm.Peer("10.0.0.2:50001"))
+	outbound.Close()
+	inbound.Close()
+}
+
+func TestPeerManagerConnectOutboundLimit(t *testing.T) {
+	node := &Node{Address: "localhost:3000", Transport: NewMemNetwork(1).Transport("localhost:3000")}
+	pm := NewPeerManager(node, &EnvConfig{})
+	pm.targetOutbound = 0
+	_, err := pm.Connect("localhost:3001")
+	assert.Contains(t, err.Error(), "outbound connection limit")
+	assert.Equal(t, 0, pm.OutboundCount())
+}
+
+// blockingTransport dials only after release is closed
+type blockingTransport struct {
+	Transport
+	release chan struct{}
+}
+
+func (t *blockingTransport) Dial(address string) (net.Conn, error) {
+	<-t.release
+	return t.Transport.Dial(address)
+}
+
+func TestPeerManagerDialCountsTowardsTarget(t *testing.T) {
+	transport := &blockingTransport{NewMemNetwork(1).Transport("localhost:3000"), make(chan struct{})}
+	node := &Node{Address: "localhost:3000", Transport: transport}
+	pm := NewPeerManager(node, &EnvConfig{})
+	pm.targetOutbound = 1
+	pm.seeds = []string{"localhost:3001", "localhost:3002"}
+	pm.maintainOutbound()
+	waitFor(t, time.Second, func() bool {
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		return pm.dialing["localhost:3001"]
+	})
+	assert.Equal(t, []string{"localhost:3002"}, pm.candidates())
+	_, err := pm.Connect("localhost:3002")
+	assert.Contains(t, err.Error(), "outbound connection limit")
+	close(transport.release)
+	waitFor(t, time.Second, func() bool {
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		return pm.backoff["localhost:3001"] != nil
+	})
+	_, err = pm.Connect("localhost:3002")
+	assert.NotContains(t, err.Error(), "outbound connection limit")
+}
+
+func TestPeerManagerRegisterUpdatesAddr(t *testing.T) {
+	node := &Node{Address: "localhost:3000"}
+	pm := NewPeerManager(node, &EnvConfig{})
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := NewPeer(conn, "localhost:50001", true, &MainParams)
+	pm.peers[peer.Addr()] = peer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			peer.Info()
+		}
+	}()
+	assert.True(t, pm.Register("localhost:3001", peer, 1))
+	<-done
+	assert.Equal(t, "localhost:3001", peer.Addr())
+	assert.Equal(t, peer, pm.Peer("localhost:3001"))
+	assert.Nil(t, pm.Peer("localhost:50001"))
+}
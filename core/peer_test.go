@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerPingPong(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := NewPeer(local, "localhost:3001", false, &MainParams)
+	assert.True(t, peer.Ping(time.Minute))
+	nonce := peer.pingNonce
+	assert.NotZero(t, nonce)
+	assert.True(t, peer.Ping(time.Minute))
+	assert.Equal(t, nonce, peer.pingNonce)
+	assert.False(t, peer.Pong(nonce+1))
+	time.Sleep(time.Millisecond)
+	assert.True(t, peer.Pong(nonce))
+	assert.True(t, peer.Latency() > 0)
+	assert.Equal(t, peer.Latency(), peer.Info().Latency)
+	peer.Close()
+}
+
+func TestPeerPingTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := NewPeer(local, "localhost:3001", false, &MainParams)
+	assert.True(t, peer.Ping(time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	assert.False(t, peer.Ping(time.Millisecond))
+	peer.Close()
+}
+
+func TestPeerHandshakeTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := NewPeer(local, "localhost:3001", true, &MainParams)
+	assert.Nil(t, peer.startHandshake(10*time.Millisecond))
+	_, err := peer.ReadMessage()
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	peer.Close()
+}
+
+func TestBroadcastSkipsStalledPeer(t *testing.T) {
+	node := &Node{Address: "localhost:3000"}
+	node.Peers = NewPeerManager(node, &EnvConfig{})
+	local, remote := net.Pipe()
+	defer remote.Close()
+	stalled := NewPeer(local, "localhost:3001", false, &MainParams)
+	stalled.completeHandshake()
+	node.Peers.peers[stalled.Addr()] = stalled
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*PeerSendQueueSize; i++ {
+			node.broadcastInventory("transaction", []byte{byte(i)}, nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcast blocked on stalled peer")
+	}
+	assert.Equal(t, PeerSendQueueSize, len(stalled.send))
+	assert.False(t, stalled.TrySend("ping", nil))
+	stalled.Close()
+}
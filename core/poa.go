@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// errNotInTurn is returned when authority tries to seal block of another authority
+var errNotInTurn = errors.New("authority is not in turn to seal block")
+
+// ProofOfAuthority is consensus engine of permissioned network where authorities take turns
+// in signing blocks: block at height h is signed by authority h modulo number of authorities.
+// Every block has the same weight, so the longest chain is preferred.
+type ProofOfAuthority struct {
+	Authorities [][]byte
+	signer      *Wallet
+	mu          sync.RWMutex
+}
+
+// NewProofOfAuthority creates proof of authority engine for authority addresses of network in rotation order
+func NewProofOfAuthority(params *ChainParams, authorities []string) (*ProofOfAuthority, error) {
+	if len(authorities) == 0 {
+		return nil, fmt.Errorf("proof of authority requires at least one authority")
+	}
+	poa := &ProofOfAuthority{}
+	for _, address := range authorities {
+		pubKeyHash, err := params.DecodeAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		poa.Authorities = append(poa.Authorities, pubKeyHash)
+	}
+	return poa, nil
+}
+
+// Authorize sets wallet of authority used to sign blocks sealed by this node
+func (poa *ProofOfAuthority) Authorize(wallet *Wallet) error {
+	if wallet == nil {
+		return fmt.Errorf("authority wallet not found")
+	}
+	if !poa.isAuthority(RipeMd160Sha256(wallet.PublicKey)) {
+		return fmt.Errorf("address %s is not an authority", wallet.GetAddress())
+	}
+	poa.mu.Lock()
+	defer poa.mu.Unlock()
+	poa.signer = wallet
+	return nil
+}
+
+// InTurn gets public key hash of authority that signs block at height
+func (poa *ProofOfAuthority) InTurn(height int) []byte {
+	return poa.Authorities[height%len(poa.Authorities)]
+}
+
+func (poa *ProofOfAuthority) isAuthority(pubKeyHash []byte) bool {
+	for _, authority := range poa.Authorities {
+		if bytes.Equal(authority, pubKeyHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prepare sets signer of block, it fails when authorized signer is not in turn
+func (poa *ProofOfAuthority) Prepare(chain *Blockchain, block *Block) error {
+	poa.mu.RLock()
+	signer := poa.signer
+	poa.mu.RUnlock()
+	if signer == nil {
+		return fmt.Errorf("node is not authorized to seal blocks")
+	}
+	if !bytes.Equal(RipeMd160Sha256(signer.PublicKey), poa.InTurn(block.Height)) {
+		return errNotInTurn
+	}
+	block.Difficulty = 0
+	block.Nonce = 0
+	block.Signer = signer.PublicKey
+	block.Hash = block.computeHash()
+	return nil
+}
+
+// Seal signs block hash with key of authority
+func (poa *ProofOfAuthority) Seal(ctx context.Context, block *Block) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	poa.mu.RLock()
+	signer := poa.signer
+	poa.mu.RUnlock()
+	if signer == nil || !bytes.Equal(block.Signer, signer.PublicKey) {
+		return fmt.Errorf("block %x is not prepared by authorized signer", block.Hash)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, &signer.PrivateKey, block.Hash)
+	if err != nil {
+		return err
+	}
+	block.Signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return nil
+}
+
+// VerifySeal checks block is signed by authority in turn
+func (poa *ProofOfAuthority) VerifySeal(chain *Blockchain, block *Block) error {
+	if block.Difficulty != 0 || block.Nonce != 0 {
+		return misbehavior(ScoreInvalidBlock, "proof of authority block with difficulty or nonce [hash:%x]", block.Hash)
+	}
+	if !bytes.Equal(RipeMd160Sha256(block.Signer), poa.InTurn(block.Height)) {
+		return misbehavior(ScoreInvalidBlock, "block at height %d is not signed by authority in turn [hash:%x]", block.Height, block.Hash)
+	}
+	if !verifySignature(block.Signer, block.Hash, block.Signature) {
+		return misbehavior(ScoreInvalidBlock, "invalid block signature [hash:%x]", block.Hash)
+	}
+	return nil
+}
+
+// Weight of every block is the same
+func (poa *ProofOfAuthority) Weight(block *Block) int {
+	return 1
+}
+
+// verifySignature checks signature made of r and s halves by public key made of x and y halves
+func verifySignature(pubKey, hash, signature []byte) bool {
+	if len(pubKey) == 0 || len(signature) == 0 {
+		return false
+	}
+	x, y := &big.Int{}, &big.Int{}
+	x.SetBytes(pubKey[:len(pubKey)/2])
+	y.SetBytes(pubKey[len(pubKey)/2:])
+	r, s := &big.Int{}, &big.Int{}
+	r.SetBytes(signature[:len(signature)/2])
+	s.SetBytes(signature[len(signature)/2:])
+	rawPubKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	return ecdsa.Verify(rawPubKey, hash, r, s)
+}
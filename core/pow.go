@@ -1,43 +1,180 @@
 package core
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
-	"fmt"
+	"encoding/binary"
 	"math"
-	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Difficulty of challenge
 const Difficulty = 3
 
-// POW finds POW: sha256 hash that starts with N (difficulty) zeros
-func (block *Block) POW() {
-	prefixTarget := strings.Repeat("0", Difficulty)
-	for nonce := 0; nonce < math.MaxInt64; nonce++ {
-		hash := sha256.Sum256(block.join(nonce))
-		prefix := fmt.Sprintf("%x", hash)[0:Difficulty]
-		if prefixTarget == prefix {
+// RegtestDifficulty is trivial difficulty of regtest network, any hash meets it
+const RegtestDifficulty = 0
+
+// MaxNonce is the largest nonce tried before block timestamp is rolled forward
+const MaxNonce = math.MaxUint32
+
+// ProofOfWork is consensus engine where block hash has to meet chain difficulty,
+// chain that took the most expected hashes to build is preferred
+type ProofOfWork struct {
+	Workers int
+	hashes  uint64
+}
+
+// NewProofOfWork creates proof of work engine searching nonces on workers goroutines
+func NewProofOfWork(workers int) *ProofOfWork {
+	return &ProofOfWork{Workers: workers}
+}
+
+// Hashes gets number of hashes computed by engine
+func (pow *ProofOfWork) Hashes() uint64 {
+	return atomic.LoadUint64(&pow.hashes)
+}
+
+// Prepare sets difficulty required by chain
+func (pow *ProofOfWork) Prepare(chain *Blockchain, block *Block) error {
+	block.Difficulty = chain.Difficulty()
+	block.Signer = nil
+	block.Signature = nil
+	block.Hash = block.computeHash()
+	return nil
+}
+
+// Seal searches nonce meeting block difficulty
+func (pow *ProofOfWork) Seal(ctx context.Context, block *Block) error {
+	hashes, err := block.POW(ctx, pow.Workers)
+	atomic.AddUint64(&pow.hashes, hashes)
+	return err
+}
+
+// VerifySeal checks block difficulty and its proof of work
+func (pow *ProofOfWork) VerifySeal(chain *Blockchain, block *Block) error {
+	if block.Difficulty != chain.Difficulty() {
+		return misbehavior(ScoreInvalidBlock, "block difficulty %d, required %d [hash:%x]", block.Difficulty, chain.Difficulty(), block.Hash)
+	}
+	if !block.ValidatePOW() {
+		return misbehavior(ScoreInvalidBlock, "block hash does not meet difficulty [hash:%x]", block.Hash)
+	}
+	return nil
+}
+
+// Weight gets expected number of hashes needed to meet block difficulty
+func (pow *ProofOfWork) Weight(block *Block) int {
+	return 1 << uint(4*block.Difficulty)
+}
+
+// POW finds POW: sha256 hash that starts with N (block difficulty) zeros.
+// Nonce range is split between workers searching in parallel, when the whole range is exhausted
+// timestamp is moved one second forward and search starts again.
+// Returns number of computed hashes, search stops with context error when ctx is cancelled.
+func (block *Block) POW(ctx context.Context, workers int) (uint64, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	var hashes uint64
+	for {
+		header := block.header()
+		nonce, found, err := searchNonce(ctx, header, block.Difficulty, workers, &hashes)
+		if err != nil {
+			return hashes, err
+		}
+		if found {
+			hash := sha256.Sum256(joinNonce(header, nonce))
 			block.Nonce = nonce
 			block.Hash = hash[:]
-			return
+			return hashes, nil
+		}
+		block.Timestamp++
+	}
+}
+
+// searchNonce searches disjoint ranges of nonces for hash meeting difficulty with workers goroutines,
+// returns false when no nonce in range meets difficulty
+func searchNonce(ctx context.Context, header []byte, difficulty, workers int, hashes *uint64) (int, bool, error) {
+	search, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan int, workers)
+	span := (MaxNonce + 1) / workers
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := w*span, (w+1)*span
+		if w == workers-1 {
+			end = MaxNonce + 1
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			var count uint64
+			defer func() { atomic.AddUint64(hashes, count) }()
+			for nonce := start; nonce < end; nonce++ {
+				if count%1024 == 0 && search.Err() != nil {
+					return
+				}
+				hash := sha256.Sum256(joinNonce(header, nonce))
+				count++
+				if meetsDifficulty(hash[:], difficulty) {
+					found <- nonce
+					cancel()
+					return
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	select {
+	case nonce := <-found:
+		return nonce, true, nil
+	default:
+	}
+	if ctx.Err() != nil {
+		return 0, false, ctx.Err()
+	}
+	return 0, false, nil
+}
+
+// meetsDifficulty checks if hash starts with N (difficulty) zero hex digits
+func meetsDifficulty(hash []byte, difficulty int) bool {
+	if len(hash) == 0 || len(hash)*2 < difficulty {
+		return false
+	}
+	for i := 0; i < difficulty; i++ {
+		digit := hash[i/2] >> 4
+		if i%2 == 1 {
+			digit = hash[i/2] & 0x0f
+		}
+		if digit != 0 {
+			return false
 		}
 	}
-	panic(nil)
+	return true
 }
 
 // ValidatePOW block hash
 func (block *Block) ValidatePOW() bool {
-	actual := fmt.Sprintf("%x", block.Hash)[0:Difficulty]
-	required := strings.Repeat("0", Difficulty)
-	return strings.HasPrefix(actual, required)
+	return meetsDifficulty(block.Hash, block.Difficulty)
+}
+
+// header encodes block fields covered by block hash except nonce: previous block hash, merkle root,
+// timestamp, difficulty and signer. Signature of block is not covered.
+func (block *Block) header() []byte {
+	var enc canonicalEncoder
+	enc.writeBytes(block.PrevBlockHash)
+	enc.writeBytes(block.HashTransactions())
+	enc.writeInt(block.Timestamp)
+	enc.writeInt(int64(block.Difficulty))
+	enc.writeBytes(block.Signer)
+	return enc.Bytes()
 }
 
 func (block *Block) join(nonce int) []byte {
-	return bytes.Join([][]byte{
-		block.PrevBlockHash,
-		block.HashTransactions(),
-		block.Hash,
-		[]byte(fmt.Sprintf("%x", nonce)),
-	}, []byte{})
+	return joinNonce(block.header(), nonce)
+}
+
+// joinNonce appends nonce encoded as 8 byte big endian integer to copy of header
+func joinNonce(header []byte, nonce int) []byte {
+	return binary.BigEndian.AppendUint64(header[:len(header):len(header)], uint64(nonce))
 }
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPOWWorkers(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		block := &Block{time.Now().Unix(), []*Transaction{}, []byte("prev"), []byte{}, 0, 1, Difficulty, nil, nil}
+		hashes, err := block.POW(context.Background(), workers)
+		assert.Nil(t, err)
+		assert.True(t, hashes > 0)
+		assert.True(t, block.ValidatePOW())
+		assert.True(t, bytes.Equal(block.Hash, block.computeHash()))
+	}
+}
+
+func TestPOWCancel(t *testing.T) {
+	block := &Block{time.Now().Unix(), []*Transaction{}, []byte("prev"), []byte{}, 0, 1, Difficulty, nil, nil}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := block.POW(ctx, 2)
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, block.Hash)
+}
+
+func TestMeetsDifficulty(t *testing.T) {
+	assert.True(t, meetsDifficulty([]byte{0x00, 0x0f, 0xff}, 3))
+	assert.False(t, meetsDifficulty([]byte{0x00, 0x10, 0x00}, 3))
+	assert.False(t, meetsDifficulty([]byte{0x01, 0x00, 0x00}, 3))
+	assert.False(t, meetsDifficulty([]byte{}, 3))
+	assert.True(t, meetsDifficulty([]byte{0x01, 0x00, 0x00}, RegtestDifficulty))
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// requestLimit is token bucket rate and burst of single command
+type requestLimit struct {
+	rate  float64
+	burst float64
+}
+
+// requestLimits holds per peer rate limits of expensive requests.
+// Requests over the limit are dropped and add ScoreRateLimited to peer.
+var requestLimits = map[string]requestLimit{
+	"getblocks": {rate: 0.2, burst: 5},
+	"getdata":   {rate: 50, burst: 200},
+	"getaddr":   {rate: 0.1, burst: 2},
+	"addr":      {rate: 0.2, burst: 10},
+}
+
+// TokenBucket limits rate of events, it holds up to burst tokens refilled at rate per second.
+// It is safe for concurrent use.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewTokenBucket creates full token bucket
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Allow takes one token, returns false when bucket is empty
+func (tb *TokenBucket) Allow() bool {
+	return tb.allowAt(time.Now())
+}
+
+func (tb *TokenBucket) allowAt(now time.Time) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if now.After(tb.last) {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+	}
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// newRequestLimiters creates token buckets for rate limited commands
+func newRequestLimiters() map[string]*TokenBucket {
+	limiters := make(map[string]*TokenBucket)
+	for command, limit := range requestLimits {
+		limiters[command] = NewTokenBucket(limit.rate, limit.burst)
+	}
+	return limiters
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := NewTokenBucket(2, 3)
+	now := tb.last
+	assert.True(t, tb.allowAt(now))
+	assert.True(t, tb.allowAt(now))
+	assert.True(t, tb.allowAt(now))
+	assert.False(t, tb.allowAt(now))
+	assert.True(t, tb.allowAt(now.Add(500*time.Millisecond)))
+	assert.False(t, tb.allowAt(now.Add(500*time.Millisecond)))
+	for i := 0; i < 3; i++ {
+		assert.True(t, tb.allowAt(now.Add(time.Hour)))
+	}
+	assert.False(t, tb.allowAt(now.Add(time.Hour)))
+}
+
+func TestPeerAllowRequest(t *testing.T) {
+	peer := NewPeer(nil, "localhost:3001", true, &MainParams)
+	for i := 0; i < int(requestLimits["getblocks"].burst); i++ {
+		assert.True(t, peer.AllowRequest("getblocks"))
+	}
+	assert.False(t, peer.AllowRequest("getblocks"))
+	assert.True(t, peer.AllowRequest("block"))
+}
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+)
+
+// MaxBlockTemplates is number of block templates kept for submission, the oldest is dropped first
+const MaxBlockTemplates = 32
+
+// NodeRPC exposes running node to local command line clients and external miners over JSON-RPC
+type NodeRPC struct {
+	node        *Node
+	templates   map[string]*BlockTemplate
+	templateIDs []string
+	mu          sync.Mutex
+}
+
+// NewNodeRPC creates JSON-RPC service of node
+func NewNodeRPC(node *Node) *NodeRPC {
+	return &NodeRPC{node: node, templates: make(map[string]*BlockTemplate)}
+}
+
+// PeersArgs are arguments of Node.Peers call
+type PeersArgs struct{}
+
+// PeersReply is result of Node.Peers call
+type PeersReply struct {
+	Peers []PeerInfo
+}
+
+// Peers lists connected peers with their statistics
+func (r *NodeRPC) Peers(args *PeersArgs, reply *PeersReply) error {
+	reply.Peers = r.node.Peers.PeerInfos()
+	return nil
+}
+
+// BanArgs are arguments of Node.Ban and Node.Unban calls.
+// Duration is optional and uses time.ParseDuration format.
+type BanArgs struct {
+	Address  string
+	Duration string
+}
+
+// BanReply is result of Node.Ban and Node.Unban calls
+type BanReply struct {
+	Bans []BanEntry
+}
+
+// Ban bans address and disconnects matching peers
+func (r *NodeRPC) Ban(args *BanArgs, reply *BanReply) error {
+	duration := r.node.Peers.banDuration
+	if args.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(args.Duration)
+		if err != nil {
+			return err
+		}
+	}
+	r.node.Peers.Ban(args.Address, duration)
+	reply.Bans = r.node.Peers.Bans.Bans()
+	return nil
+}
+
+// Unban removes ban of address
+func (r *NodeRPC) Unban(args *BanArgs, reply *BanReply) error {
+	if !r.node.Peers.Unban(args.Address) {
+		return fmt.Errorf("address %s is not banned", args.Address)
+	}
+	reply.Bans = r.node.Peers.Bans.Bans()
+	return nil
+}
+
+// SendArgs are arguments of Node.Send call
+type SendArgs struct {
+	From        string
+	To          string
+	Amount      int
+	Fee         int
+	Replaceable bool
+}
+
+// TransactionArgs are arguments of Node.PendingTransaction and Node.SendTransaction calls.
+// ID selects pending transaction, Transaction holds serialized signed transaction.
+type TransactionArgs struct {
+	ID          string
+	Transaction []byte
+}
+
+// TransactionReply is result of transaction calls
+type TransactionReply struct {
+	ID          string
+	Transaction []byte
+	Fee         int
+}
+
+// Send creates transaction from wallet of node and submits it to mempool
+func (r *NodeRPC) Send(args *SendArgs, reply *TransactionReply) error {
+	chain := r.node.Chain
+	tx, err := chain.NewTransactionWithFee(args.From, args.To, args.Amount, args.Fee, args.Replaceable)
+	if err != nil {
+		return err
+	}
+	wallet := chain.ws.GetWallet(args.From)
+	chain.SignTransaction(&wallet.PrivateKey, tx)
+	err = r.node.SubmitTransaction(*tx)
+	if err != nil {
+		return err
+	}
+	return r.pendingTransaction(hex.EncodeToString(tx.ID), reply)
+}
+
+// PendingTransaction gets transaction from mempool
+func (r *NodeRPC) PendingTransaction(args *TransactionArgs, reply *TransactionReply) error {
+	return r.pendingTransaction(args.ID, reply)
+}
+
+// SendTransaction submits transaction signed by wallet to mempool
+func (r *NodeRPC) SendTransaction(args *TransactionArgs, reply *TransactionReply) error {
+	tx, err := r.node.Chain.Params.DecodeTransaction(args.Transaction)
+	if err != nil {
+		return err
+	}
+	err = r.node.SubmitTransaction(tx)
+	if err != nil {
+		return err
+	}
+	return r.pendingTransaction(hex.EncodeToString(tx.ID), reply)
+}
+
+func (r *NodeRPC) pendingTransaction(id string, reply *TransactionReply) error {
+	entry, ok := r.node.Mempool.Entry(id)
+	if !ok {
+		return fmt.Errorf("transaction %s is not pending", id)
+	}
+	reply.ID = id
+	reply.Transaction = entry.Tx.Serialize()
+	reply.Fee = entry.Fee
+	return nil
+}
+
+// BlockTemplateArgs are arguments of Node.GetBlockTemplate call,
+// Address receives block reward and defaults to miners address of node
+type BlockTemplateArgs struct {
+	Address string
+}
+
+// BlockTemplateReply is block template for external miner.
+// Block hash is sha256 of Header followed by nonce as 8 byte big endian integer. Header encodes PrevBlockHash,
+// MerkleRoot, Timestamp, Target and empty signer, byte slices are prefixed with their length as 4 byte
+// big endian integer and integers take 8 bytes big endian. Solved block hash has to start with Target zero hex digits.
+// Miner may roll Timestamp forward when nonce range is exhausted, it must not be less than MinTime
+// nor too far ahead of network time.
+type BlockTemplateReply struct {
+	ID            string
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	MinTime       int64
+	Height        int
+	Target        int
+	Header        []byte
+	Transactions  [][]byte
+	CoinbaseValue int
+	Fees          int
+}
+
+// SubmitBlockArgs are arguments of Node.SubmitBlock call with solution of template with given ID
+type SubmitBlockArgs struct {
+	ID        string
+	Timestamp int64
+	Nonce     int
+}
+
+// SubmitBlockReply is result of Node.SubmitBlock call
+type SubmitBlockReply struct {
+	Hash   string
+	Height int
+}
+
+// GetBlockTemplate builds block template from mempool on top of current tip.
+// Templates stay valid for submission until chain tip changes or MaxBlockTemplates newer ones are built.
+func (r *NodeRPC) GetBlockTemplate(args *BlockTemplateArgs, reply *BlockTemplateReply) error {
+	address, err := r.rewardAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.node.Chain.Engine.(*ProofOfWork); !ok {
+		return fmt.Errorf("block templates are available only with proof of work consensus")
+	}
+	env := r.node.Env
+	template := NewBlockTemplate(r.node.Chain, r.node.Mempool, address, env.GetBlockMaxBytes())
+	block := template.NewBlock(template.Timestamp, 0)
+	id := hex.EncodeToString(block.HashTransactions())
+	r.mu.Lock()
+	r.storeTemplate(id, template)
+	r.mu.Unlock()
+
+	reply.ID = id
+	reply.PrevBlockHash = template.PrevBlockHash
+	reply.MerkleRoot = block.HashTransactions()
+	reply.Timestamp = block.Timestamp
+	reply.MinTime = template.MinTimestamp
+	reply.Height = template.Height
+	reply.Target = template.Difficulty
+	reply.Header = block.header()
+	reply.Transactions = nil
+	for _, tx := range template.Transactions {
+		reply.Transactions = append(reply.Transactions, tx.Serialize())
+	}
+	reply.CoinbaseValue = template.Transactions[len(template.Transactions)-1].Vout[0].Value
+	reply.Fees = template.Fees
+	return nil
+}
+
+// storeTemplate keeps template for submission, templates on top of another tip and the oldest
+// ones over MaxBlockTemplates are dropped
+func (r *NodeRPC) storeTemplate(id string, template *BlockTemplate) {
+	var ids []string
+	for _, key := range r.templateIDs {
+		if key != id && bytes.Equal(r.templates[key].PrevBlockHash, template.PrevBlockHash) {
+			ids = append(ids, key)
+		} else {
+			delete(r.templates, key)
+		}
+	}
+	ids = append(ids, id)
+	r.templates[id] = template
+	for len(ids) > MaxBlockTemplates {
+		delete(r.templates, ids[0])
+		ids = ids[1:]
+	}
+	r.templateIDs = ids
+}
+
+// SubmitBlock validates block solved by external miner, adds it to chain and announces it to peers
+func (r *NodeRPC) SubmitBlock(args *SubmitBlockArgs, reply *SubmitBlockReply) error {
+	r.mu.Lock()
+	template := r.templates[args.ID]
+	r.mu.Unlock()
+	if template == nil {
+		return fmt.Errorf("unknown block template %s", args.ID)
+	}
+	block := template.NewBlock(args.Timestamp, args.Nonce)
+	if !block.ValidatePOW() {
+		return fmt.Errorf("block hash %x does not meet target", block.Hash)
+	}
+	err := r.node.SubmitBlock(block)
+	if err == errStaleBlock {
+		return fmt.Errorf("block template %s is stale", args.ID)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("accepted block from external miner [height: %d] [hash: %x]\n", block.Height, block.Hash)
+	reply.Hash = hex.EncodeToString(block.Hash)
+	reply.Height = block.Height
+	return nil
+}
+
+// GenerateArgs are arguments of Node.Generate call, Address defaults to miners address of node
+type GenerateArgs struct {
+	Count   int
+	Address string
+}
+
+// GenerateReply is result of Node.Generate call
+type GenerateReply struct {
+	Hashes []string
+}
+
+// Generate immediately mines blocks on regtest network
+func (r *NodeRPC) Generate(args *GenerateArgs, reply *GenerateReply) error {
+	if r.node.Chain.Params.Name != NetworkRegtest {
+		return fmt.Errorf("generate is available only on %s network", NetworkRegtest)
+	}
+	address, err := r.rewardAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	hashes, err := r.node.Generate(args.Count, address)
+	for _, hash := range hashes {
+		reply.Hashes = append(reply.Hashes, hex.EncodeToString(hash))
+	}
+	return err
+}
+
+// rewardAddress validates address receiving block reward, it defaults to miners address of node
+func (r *NodeRPC) rewardAddress(address string) (string, error) {
+	if address == "" {
+		address = r.node.MinersAdds
+	}
+	if address == "" {
+		return "", fmt.Errorf("address receiving block reward is required")
+	}
+	_, err := r.node.Chain.Params.DecodeAddress(address)
+	return address, err
+}
+
+// StartRPC starts JSON-RPC server on node RPC address, server stops when returned listener is closed
+func (node *Node) StartRPC() (net.Listener, error) {
+	server := rpc.NewServer()
+	err := server.RegisterName("Node", NewNodeRPC(node))
+	if err != nil {
+		return nil, err
+	}
+	address := node.Env.GetRPCAddress(node.Port)
+	listen, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("rpc server listening on: %s\n", address)
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				fmt.Printf("rpc server stopped: %s\n", err)
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return listen, nil
+}
+
+// DialRPC connects to JSON-RPC server of running node
+func DialRPC(config Config, nodeID string) (*rpc.Client, error) {
+	return jsonrpc.Dial("tcp", config.GetRPCAddress(nodeID))
+}
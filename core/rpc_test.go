@@ -0,0 +1,90 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRPCBlockTemplateSubmit(t *testing.T) {
+	node := newTestNode(t, NewMemNetwork(1), t.TempDir(), "4601")
+	wallet, miner := NewWallet(), NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := mineTestBlocks(t, node, 1, address)[0]
+	tx := newTestTx(wallet, cbTx, 0, *NewTxOutput(45, address))
+	assert.Nil(t, node.Mempool.Add(*tx))
+	r := NewNodeRPC(node)
+
+	var template BlockTemplateReply
+	assert.NotNil(t, r.GetBlockTemplate(&BlockTemplateArgs{}, &template))
+	assert.Nil(t, r.GetBlockTemplate(&BlockTemplateArgs{Address: string(miner.GetAddress())}, &template))
+	tip, height := node.Chain.Tip()
+	assert.Equal(t, tip, template.PrevBlockHash)
+	assert.Equal(t, height+1, template.Height)
+	assert.Equal(t, 2, len(template.Transactions))
+	assert.Equal(t, 55, template.CoinbaseValue)
+	assert.Equal(t, 5, template.Fees)
+
+	var reply SubmitBlockReply
+	assert.NotNil(t, r.SubmitBlock(&SubmitBlockArgs{ID: "unknown"}, &reply))
+	nonce := 0
+	for ; ; nonce++ {
+		hash := sha256.Sum256(binary.BigEndian.AppendUint64(append([]byte{}, template.Header...), uint64(nonce)))
+		if meetsDifficulty(hash[:], template.Target) {
+			break
+		}
+	}
+	assert.NotNil(t, r.SubmitBlock(&SubmitBlockArgs{template.ID, template.Timestamp, nonce + 1}, &reply))
+	assert.Nil(t, r.SubmitBlock(&SubmitBlockArgs{template.ID, template.Timestamp, nonce}, &reply))
+	tip, newHeight := node.Chain.Tip()
+	assert.Equal(t, height+1, newHeight)
+	assert.Equal(t, fmt.Sprintf("%x", tip), reply.Hash)
+	assert.Equal(t, 0, node.Mempool.Len())
+	assert.Equal(t, 55, node.Chain.GetBalance(string(miner.GetAddress())))
+
+	assert.NotNil(t, r.SubmitBlock(&SubmitBlockArgs{template.ID, template.Timestamp, nonce}, &reply))
+}
+
+func TestRPCBlockTemplatesLimit(t *testing.T) {
+	node := newTestNode(t, NewMemNetwork(1), t.TempDir(), "4604")
+	r := NewNodeRPC(node)
+	var ids []string
+	for i := 0; i <= MaxBlockTemplates; i++ {
+		var template BlockTemplateReply
+		assert.Nil(t, r.GetBlockTemplate(&BlockTemplateArgs{Address: string(NewWallet().GetAddress())}, &template))
+		ids = append(ids, template.ID)
+	}
+	assert.Len(t, r.templates, MaxBlockTemplates)
+	assert.Nil(t, r.templates[ids[0]])
+	assert.NotNil(t, r.templates[ids[MaxBlockTemplates]])
+
+	mineTestBlocks(t, node, 1, string(NewWallet().GetAddress()))
+	var template BlockTemplateReply
+	assert.Nil(t, r.GetBlockTemplate(&BlockTemplateArgs{Address: string(NewWallet().GetAddress())}, &template))
+	assert.Len(t, r.templates, 1)
+	assert.Equal(t, []string{template.ID}, r.templateIDs)
+}
+
+func TestRPCGenerateRegtest(t *testing.T) {
+	r := NewNodeRPC(newTestNode(t, NewMemNetwork(1), t.TempDir(), "4602"))
+	var reply GenerateReply
+	assert.NotNil(t, r.Generate(&GenerateArgs{Count: 1, Address: string(NewWallet().GetAddress())}, &reply))
+
+	node := newTestConfigNode(t, NewMemNetwork(1), &testConfig{dir: t.TempDir(), network: NetworkRegtest}, "4603")
+	r = NewNodeRPC(node)
+	assert.NotNil(t, r.Generate(&GenerateArgs{Count: 1, Address: string(NewWallet().GetAddress())}, &reply))
+	address := string(NewNetworkWallet(&RegtestParams).GetAddress())
+	assert.NotNil(t, r.Generate(&GenerateArgs{Count: 1}, &reply))
+	assert.Nil(t, r.Generate(&GenerateArgs{Count: 5, Address: address}, &reply))
+	assert.Equal(t, 5, len(reply.Hashes))
+	tip, height := node.Chain.Tip()
+	assert.Equal(t, 5, height)
+	assert.Equal(t, fmt.Sprintf("%x", tip), reply.Hashes[4])
+	assert.Equal(t, 250, node.Chain.GetBalance(address))
+
+	block := NewBlock([]*Transaction{NewCoinbaseTransaction(address, "main", 50)}, tip, height+1, Difficulty)
+	assert.NotNil(t, node.Chain.ValidateBlock(block))
+}
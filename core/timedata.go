@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MinTimeSamples is number of peer clocks needed before local clock is adjusted
+const MinTimeSamples = 5
+
+// MaxTimeSamples limits number of peer hosts whose clocks are sampled
+const MaxTimeSamples = 200
+
+// MaxTimeOffset is the largest adjustment of local clock, larger median offset is ignored
+const MaxTimeOffset = 70 * time.Minute
+
+// TimeSource gives network adjusted time: local clock moved by median offset of peer clocks.
+// Every remote host contributes single sample taken from timestamp of version message of its first connection,
+// so peer cannot move the median by reconnecting or declaring other addresses.
+type TimeSource struct {
+	offsets map[string]time.Duration
+	offset  time.Duration
+	mu      sync.RWMutex
+}
+
+// NewTimeSource creates time source following local clock until peers are sampled
+func NewTimeSource() *TimeSource {
+	return &TimeSource{offsets: make(map[string]time.Duration)}
+}
+
+// AddSample records offset of clock of peer host at timestamp from local clock and recomputes adjustment
+func (ts *TimeSource) AddSample(host string, timestamp int64) {
+	ts.addSampleAt(host, timestamp, time.Now())
+}
+
+func (ts *TimeSource) addSampleAt(host string, timestamp int64, now time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.offsets[host]; ok || len(ts.offsets) >= MaxTimeSamples {
+		return
+	}
+	ts.offsets[host] = time.Unix(timestamp, 0).Sub(now).Round(time.Second)
+	if len(ts.offsets) < MinTimeSamples {
+		return
+	}
+	var offsets []time.Duration
+	for _, offset := range ts.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	median := offsets[len(offsets)/2]
+	if median > MaxTimeOffset || median < -MaxTimeOffset {
+		fmt.Printf("peer clocks are %s away from local clock, check that local time is correct\n", median)
+		ts.offset = 0
+		return
+	}
+	ts.offset = median
+}
+
+// Offset gets adjustment of local clock
+func (ts *TimeSource) Offset() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.offset
+}
+
+// Now gets network adjusted time
+func (ts *TimeSource) Now() time.Time {
+	return time.Now().Add(ts.Offset())
+}
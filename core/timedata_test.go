@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeSourceMedianOffset(t *testing.T) {
+	ts := NewTimeSource()
+	now := time.Unix(1704067200, 0)
+	ahead := now.Add(10 * time.Minute).Unix()
+	for i := 0; i < MinTimeSamples-1; i++ {
+		ts.addSampleAt(fmt.Sprintf("10.0.0.%d", i), ahead, now)
+	}
+	ts.addSampleAt("10.0.0.0", ahead, now)
+	assert.Equal(t, time.Duration(0), ts.Offset())
+	ts.addSampleAt("10.0.0.9", now.Add(-time.Minute).Unix(), now)
+	assert.Equal(t, 10*time.Minute, ts.Offset())
+	assert.True(t, ts.Now().After(time.Now().Add(9*time.Minute)))
+
+	far := NewTimeSource()
+	for i := 0; i < MinTimeSamples; i++ {
+		far.addSampleAt(fmt.Sprintf("10.0.0.%d", i), now.Add(2*MaxTimeOffset).Unix(), now)
+	}
+	assert.Equal(t, time.Duration(0), far.Offset())
+}
+
+func TestValidateBlockTimestamp(t *testing.T) {
+	chain := newTestChain(t)
+	address := string(NewWallet().GetAddress())
+	for i := 1; i <= MedianTimeSpan; i++ {
+		_, err := chain.MineBlock([]*Transaction{NewCoinbaseTransaction(address, fmt.Sprintf("%d", i), 50)})
+		assert.Nil(t, err)
+	}
+	tip, height := chain.Tip()
+	mtp := chain.MedianTimePast(tip)
+	assert.True(t, chain.NextTimestamp(tip) > mtp)
+	newBlock := func(timestamp int64) *Block {
+		cbTx := NewCoinbaseTransaction(address, fmt.Sprintf("%d", timestamp), 50)
+		block := &Block{timestamp, []*Transaction{cbTx}, tip, []byte{}, 0, height + 1, 0, nil, nil}
+		assert.Nil(t, chain.Engine.Prepare(chain, block))
+		assert.Nil(t, chain.Engine.Seal(context.Background(), block))
+		return block
+	}
+
+	assert.True(t, isProtocolError(chain.ValidateBlock(newBlock(mtp))))
+	future := chain.ValidateBlock(newBlock(time.Now().Add(chain.Params.MaxFutureBlockTime + time.Minute).Unix()))
+	assert.NotNil(t, future)
+	assert.False(t, isProtocolError(future))
+	assert.Nil(t, chain.ValidateBlock(newBlock(mtp+1)))
+}
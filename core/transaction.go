@@ -22,7 +22,7 @@ type Transaction struct {
 
 // NewCoinbaseTransaction creates new coinbase transaction
 func NewCoinbaseTransaction(to, data string, reward int) *Transaction {
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
+	txin := TxInput{[]byte{}, -1, nil, []byte(data), SequenceFinal}
 	txout := NewTxOutput(reward, to)
 	tx := &Transaction{[]byte{}, []TxInput{txin}, []TxOutput{*txout}}
 	tx.ID = tx.Hash()
@@ -42,34 +42,77 @@ func (tx *Transaction) Serialize() []byte {
 
 // DeserializeTransaction serializes the transaction
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := DecodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
 	return transaction
 }
 
+// DecodeTransaction deserializes transaction received from network, data larger than maximum transaction size
+// is rejected before decoding
+func (params *ChainParams) DecodeTransaction(data []byte) (Transaction, error) {
+	if len(data) > params.MaxTxSize {
+		return Transaction{}, fmt.Errorf("transaction of %d bytes exceeds maximum transaction size %d", len(data), params.MaxTxSize)
+	}
+	return DecodeTransaction(data)
+}
+
+// DecodeTransaction deserializes the transaction, returns error for malformed data
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&transaction)
+	return transaction, err
+}
+
 // IsCoinbase checks whether the transaction is coinbase
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Hash returns transaction hash
+// SignalsReplacement checks if any input opts transaction into replace-by-fee
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, in := range tx.Vin {
+		if in.SignalsReplacement() {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash returns transaction hash, sha256 of canonical encoding of transaction without its id
 func (tx *Transaction) Hash() []byte {
-	tcopy := *tx
-	tcopy.ID = []byte{}
-	hash := sha256.Sum256(tcopy.Serialize())
+	hash := sha256.Sum256(tx.encode())
 	return hash[:]
 }
 
+// encode gets canonical encoding of transaction without its id: number of inputs and every input as txid,
+// output index, signature, public key and sequence, then number of outputs and every output as value and public key hash
+func (tx *Transaction) encode() []byte {
+	var enc canonicalEncoder
+	enc.writeUint32(uint32(len(tx.Vin)))
+	for _, in := range tx.Vin {
+		enc.writeBytes(in.Txid)
+		enc.writeInt(int64(in.Vout))
+		enc.writeBytes(in.Signature)
+		enc.writeBytes(in.PubKey)
+		enc.writeUint32(in.Sequence)
+	}
+	enc.writeUint32(uint32(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		enc.writeInt(int64(out.Value))
+		enc.writeBytes(out.PubKeyHash)
+	}
+	return enc.Bytes()
+}
+
 // AsSignaturePayload makes copy of transaction with required elements for signing
 func (tx *Transaction) AsSignaturePayload() Transaction {
 	var ins []TxInput
 	var outs []TxOutput
 	for _, i := range tx.Vin {
-		ins = append(ins, TxInput{i.Txid, i.Vout, nil, nil})
+		ins = append(ins, TxInput{i.Txid, i.Vout, nil, nil, i.Sequence})
 	}
 	for _, o := range tx.Vout {
 		outs = append(outs, TxOutput{o.Value, o.PubKeyHash})
@@ -77,7 +120,7 @@ func (tx *Transaction) AsSignaturePayload() Transaction {
 	return Transaction{tx.ID, ins, outs}
 }
 
-// Sign signs transaction using private key
+// Sign signs transaction using private key, transaction id is updated to hash of signed transaction
 func (tx *Transaction) Sign(pk *ecdsa.PrivateKey, previousTxs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
@@ -92,14 +135,24 @@ func (tx *Transaction) Sign(pk *ecdsa.PrivateKey, previousTxs map[string]Transac
 		x, y, _ := ecdsa.Sign(rand.Reader, pk, payload.Hash())
 		tx.Vin[ii].Signature = append(x.Bytes(), y.Bytes()...)
 	}
+	tx.ID = tx.Hash()
 }
 
 // Verify verifies transaction
 func (tx *Transaction) Verify(previousTxs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
 	curve := elliptic.P256()
 	payload := tx.AsSignaturePayload()
 	for ii, i := range tx.Vin {
 		previousTx := previousTxs[hex.EncodeToString(i.Txid)]
+		if i.Vout < 0 || i.Vout >= len(previousTx.Vout) {
+			return false
+		}
+		if !i.CanUnlockOutput(previousTx.Vout[i.Vout].PubKeyHash) {
+			return false
+		}
 		payload.Vin[ii].Signature = nil
 		payload.Vin[ii].PubKey = previousTx.Vout[i.Vout].PubKeyHash
 		payload.ID = payload.Hash()
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,7 +12,7 @@ func TestTxInLocking(t *testing.T) {
 	ws := NewWalletStore(&EnvConfig{}, "1")
 	wallet := ws.CreateWallet()
 	address := string(wallet.GetAddress())
-	txin := &TxInput{[]byte("1"), 0, nil, wallet.PublicKey}
+	txin := &TxInput{[]byte("1"), 0, nil, wallet.PublicKey, SequenceFinal}
 	pubKeyHash, _ := PubKeyHash(address)
 	assert.True(t, txin.CanUnlockOutput(pubKeyHash))
 }
@@ -47,3 +49,14 @@ func TestAsSignaturePayload(t *testing.T) {
 		assert.Equal(t, o, dt2.Vout[oi])
 	}
 }
+
+func TestTransactionHashEncoding(t *testing.T) {
+	tx := &Transaction{[]byte("id"), []TxInput{{[]byte{0xaa}, 1, nil, []byte{0xbb}, 2}}, []TxOutput{{3, []byte{0xcc}}}}
+	encoded, _ := hex.DecodeString("00000001" + "00000001aa" + "0000000000000001" + "00000000" + "00000001bb" + "00000002" +
+		"00000001" + "0000000000000003" + "00000001cc")
+	hash := sha256.Sum256(encoded)
+	assert.Equal(t, hash[:], tx.Hash())
+	tx.ID = nil
+	tx.Vin[0].Signature = []byte{}
+	assert.Equal(t, hash[:], tx.Hash())
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// DialTimeout is how long dialing peer may take, including TLS handshake
+const DialTimeout = 10 * time.Second
+
+// Transport opens connections between nodes
+type Transport interface {
+	Listen(address string) (net.Listener, error)
+	Dial(address string) (net.Conn, error)
+}
+
+// TCPTransport connects nodes over TCP, encrypted with TLS when config is set
+type TCPTransport struct {
+	TLS *tls.Config
+}
+
+// Listen listens on TCP address
+func (t *TCPTransport) Listen(address string) (net.Listener, error) {
+	if t.TLS != nil {
+		return tls.Listen("tcp", address, t.TLS)
+	}
+	return net.Listen("tcp", address)
+}
+
+// Dial connects to TCP address within DialTimeout, tls handshake is completed before connection is returned
+func (t *TCPTransport) Dial(address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	if t.TLS != nil {
+		return tls.DialWithDialer(dialer, "tcp", address, t.TLS)
+	}
+	return dialer.Dial("tcp", address)
+}
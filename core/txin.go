@@ -6,12 +6,24 @@ import (
 	"fmt"
 )
 
+// SequenceFinal is sequence of input that does not allow replacement of its transaction
+const SequenceFinal = 0xffffffff
+
+// MaxRBFSequence is the highest input sequence that opts transaction into replace-by-fee
+const MaxRBFSequence = 0xfffffffd
+
 // TxInput transaction input
 type TxInput struct {
 	Txid      []byte
 	Vout      int
 	Signature []byte
 	PubKey    []byte
+	Sequence  uint32
+}
+
+// SignalsReplacement checks if input allows its transaction to be replaced by one paying higher fee
+func (txin *TxInput) SignalsReplacement() bool {
+	return txin.Sequence <= MaxRBFSequence
 }
 
 // CanUnlockOutput checks if key can unlock output
@@ -27,8 +39,7 @@ func (txin *TxInput) Log() {
 		template := "\t\t[tx:%s]\n\t\t[out:%d] [sign:%d] [pubkey:%s]\n"
 		fmt.Printf(template, hex.EncodeToString(txin.Txid), txin.Vout, len(txin.Signature), string(txin.PubKey))
 	} else {
-		template := "\t\t[tx:%s]\n\t\t[out:%d] [sign:%d] [address:%s]\n"
-		address := string(GetAddressFromPublicKey(txin.PubKey))
-		fmt.Printf(template, hex.EncodeToString(txin.Txid), txin.Vout, len(txin.Signature), address)
+		template := "\t\t[tx:%s]\n\t\t[out:%d] [sign:%d] [pubkeyhash:%x]\n"
+		fmt.Printf(template, hex.EncodeToString(txin.Txid), txin.Vout, len(txin.Signature), RipeMd160Sha256(txin.PubKey))
 	}
 }
@@ -5,8 +5,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"log"
-
-	"github.com/mr-tron/base58/base58"
 )
 
 // TxOutput transaction output
@@ -15,17 +13,22 @@ type TxOutput struct {
 	PubKeyHash []byte
 }
 
-// NewTxOutput creates new TxOutput and locks it for address
+// NewTxOutput creates new TxOutput and locks it for address, it panics for invalid address
 func NewTxOutput(value int, address string) *TxOutput {
 	txo := &TxOutput{value, nil}
-	txo.LockOutput(address)
+	err := txo.LockOutput(address)
+	if err != nil {
+		panic(err)
+	}
 	return txo
 }
 
 // LockOutput locks output with public key hash of given address
 func (txout *TxOutput) LockOutput(address string) error {
-	pubKeyHash, _ := base58.Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-AddressChecksumLength]
+	pubKeyHash, err := PubKeyHash(address)
+	if err != nil {
+		return err
+	}
 	txout.PubKeyHash = pubKeyHash
 	return nil
 }
@@ -55,8 +58,11 @@ func (txout *TxOutput) Deserialize(data []byte) {
 	}
 }
 
-// SerializeOutputs deserializes outputs
-func SerializeOutputs(data []TxOutput) []byte {
+// TxOutputs holds unspent outputs of transaction by their index
+type TxOutputs map[int]TxOutput
+
+// SerializeOutputs serializes outputs
+func SerializeOutputs(data TxOutputs) []byte {
 	var buff bytes.Buffer
 	enc := gob.NewEncoder(&buff)
 	err := enc.Encode(data)
@@ -67,8 +73,8 @@ func SerializeOutputs(data []TxOutput) []byte {
 }
 
 // DeserializeOutputs deserializes outputs
-func DeserializeOutputs(data []byte) []TxOutput {
-	var outputs []TxOutput
+func DeserializeOutputs(data []byte) TxOutputs {
+	var outputs TxOutputs
 	dec := gob.NewDecoder(bytes.NewReader(data))
 	err := dec.Decode(&outputs)
 	if err != nil {
@@ -79,7 +85,6 @@ func DeserializeOutputs(data []byte) []TxOutput {
 
 // Log logs txout
 func (txout *TxOutput) Log() {
-	template := "\t\t[val:%d] [address:%s]\n"
-	address := string(GetAddressFromPublicKeyHash(txout.PubKeyHash))
-	fmt.Printf(template, txout.Value, address)
+	template := "\t\t[val:%d] [pubkeyhash:%x]\n"
+	fmt.Printf(template, txout.Value, txout.PubKeyHash)
 }
@@ -2,6 +2,7 @@ package core
 
 import (
 	"crypto/sha256"
+	"fmt"
 	"os"
 
 	"github.com/mr-tron/base58/base58"
@@ -27,13 +28,19 @@ func ShaChecksum(payload []byte, length int) []byte {
 	return sha2[:length]
 }
 
-// PubKeyHash gets the Punlic Key Hash from address
+// PubKeyHash gets the Punlic Key Hash from address of any network, network is given by address version.
+// Chain checks that addresses belong to its network with DecodeAddress of its parameters.
 func PubKeyHash(address string) ([]byte, error) {
-	pubKeyHash, err := base58.Decode(address)
-	if err != nil {
-		return nil, err
+	decoded, err := base58.Decode(address)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("invalid address %s", address)
+	}
+	for _, params := range networks {
+		if params.AddressVersion == decoded[0] {
+			return params.DecodeAddress(address)
+		}
 	}
-	return pubKeyHash[1 : len(pubKeyHash)-AddressChecksumLength], nil
+	return nil, fmt.Errorf("address %s does not belong to known network", address)
 }
 
 //FileExists checks if file exists on path
@@ -63,65 +63,109 @@ func (utxos *UtxoStore) FindSpendableOutputs(pubkeyHash []byte, amount int) (int
 	return total, unspent
 }
 
-// Reindex makes new index of all utxo in the chain
-func (utxos *UtxoStore) Reindex() error {
+// FindOutput gets unspent output by transaction id and output index
+func (utxos *UtxoStore) FindOutput(txid []byte, vout int) (TxOutput, bool) {
+	var out TxOutput
+	found := false
 	chain := utxos.Chain
-	bucket := []byte(chain.config.GetDbUtxoBucket())
-	err := chain.db.Update(func(tx *bolt.Tx) error {
-		tx.DeleteBucket(bucket)
-		tx.CreateBucket(bucket)
+	err := chain.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(chain.config.GetDbUtxoBucket())).Get(txid)
+		if data != nil {
+			out, found = DeserializeOutputs(data)[vout]
+		}
 		return nil
 	})
 	if err != nil {
-		panic(err)
+		log.Panic(err)
 	}
-	outs := chain.FindUtxo()
-	err = chain.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
-		if b == nil {
-			panic("bucket is nil")
-		}
-		for txid, out := range outs {
-			key, err2 := hex.DecodeString(txid)
-			if err2 != nil {
-				panic(err2)
-			}
-			err2 = b.Put(key, SerializeOutputs(out))
-			if err2 != nil {
-				panic(err2)
-			}
-		}
+	return out, found
+}
+
+// HasOutputs checks if transaction has unspent outputs
+func (utxos *UtxoStore) HasOutputs(txid []byte) bool {
+	found := false
+	chain := utxos.Chain
+	err := chain.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(chain.config.GetDbUtxoBucket())).Get(txid) != nil
 		return nil
 	})
-	return err
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
+
+// Reindex makes new index of all utxo in the main chain.
+// Index is replaced in single transaction so readers never see it partially built.
+func (utxos *UtxoStore) Reindex() error {
+	chain := utxos.Chain
+	return chain.db.Update(func(tx *bolt.Tx) error {
+		tip := tx.Bucket([]byte(chain.config.GetDbBucket())).Get([]byte("1"))
+		return utxos.reindex(tx, tip)
+	})
+}
+
+// reindex rebuilds utxo index of chain ending with tip within database transaction
+func (utxos *UtxoStore) reindex(tx *bolt.Tx, tip []byte) error {
+	chain := utxos.Chain
+	bucket := []byte(chain.config.GetDbUtxoBucket())
+	outs := findUtxo(tx.Bucket([]byte(chain.config.GetDbBucket())), tip)
+	tx.DeleteBucket(bucket)
+	b, err := tx.CreateBucket(bucket)
+	if err != nil {
+		return err
+	}
+	for txid, out := range outs {
+		key, err := hex.DecodeString(txid)
+		if err != nil {
+			return err
+		}
+		err = b.Put(key, SerializeOutputs(out))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Update updates utco index with new block
+// Update updates utxo index with new block
 func (utxos *UtxoStore) Update(block *Block) error {
-	db := utxos.Chain.db
-	name := utxos.Chain.config.GetDbUtxoBucket()
-	err := db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(name))
-		for _, tx := range block.Transactions {
-			// if !tx.IsCoinbase() {
-			for _, vin := range tx.Vin {
-				var updatedOuts []TxOutput
-				outs := DeserializeOutputs(bucket.Get(vin.Txid))
-				for oi, o := range outs {
-					if oi != vin.Vout {
-						updatedOuts = append(updatedOuts, o)
-					}
+	return utxos.Chain.db.Update(func(tx *bolt.Tx) error {
+		return utxos.update(tx, block)
+	})
+}
+
+// update removes outputs spent by block and adds outputs it creates within database transaction
+func (utxos *UtxoStore) update(tx *bolt.Tx, block *Block) error {
+	bucket := tx.Bucket([]byte(utxos.Chain.config.GetDbUtxoBucket()))
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, vin := range t.Vin {
+				data := bucket.Get(vin.Txid)
+				if data == nil {
+					continue
 				}
-				if len(updatedOuts) == 0 {
-					bucket.Delete(vin.Txid)
+				outs := DeserializeOutputs(data)
+				delete(outs, vin.Vout)
+				var err error
+				if len(outs) == 0 {
+					err = bucket.Delete(vin.Txid)
 				} else {
-					bucket.Put(vin.Txid, SerializeOutputs(updatedOuts))
+					err = bucket.Put(vin.Txid, SerializeOutputs(outs))
+				}
+				if err != nil {
+					return err
 				}
-				// }
-				bucket.Put(tx.ID, SerializeOutputs(tx.Vout))
 			}
 		}
-		return nil
-	})
-	return err
+		outs := make(TxOutputs)
+		for i, out := range t.Vout {
+			outs[i] = out
+		}
+		err := bucket.Put(t.ID, SerializeOutputs(outs))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
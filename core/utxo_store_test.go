@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUtxoStoreKeepsOutputIndices(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	cbTx := fundTestWallet(t, chain, wallet, "a")
+	tx := newTestTx(wallet, cbTx, 0, *NewTxOutput(20, address), *NewTxOutput(30, address))
+	spend := newTestTx(wallet, tx, 0, *NewTxOutput(20, address))
+	_, err := chain.MineBlock([]*Transaction{tx})
+	assert.Nil(t, err)
+	_, err = chain.MineBlock([]*Transaction{spend})
+	assert.Nil(t, err)
+
+	utxos := UtxoStore{chain}
+	_, ok := utxos.FindOutput(tx.ID, 0)
+	assert.False(t, ok)
+	out, ok := utxos.FindOutput(tx.ID, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 30, out.Value)
+	assert.Equal(t, 50, chain.GetBalance(address))
+
+	assert.Nil(t, utxos.Reindex())
+	out, ok = utxos.FindOutput(tx.ID, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 30, out.Value)
+}
@@ -0,0 +1,240 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// MedianTimeSpan is number of previous blocks whose median timestamp block has to exceed
+const MedianTimeSpan = 11
+
+// MaxTipAge is age of chain tip after which node considers itself in initial block download
+const MaxTipAge = 24 * time.Hour
+
+// CheckTransactionSanity performs checks of transaction that do not depend on chain state, size is limited by network
+func CheckTransactionSanity(tx *Transaction, params *ChainParams) error {
+	if len(tx.Vin) == 0 {
+		return fmt.Errorf("transaction has no inputs")
+	}
+	if len(tx.Vout) == 0 {
+		return fmt.Errorf("transaction has no outputs")
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return fmt.Errorf("transaction id is not its hash")
+	}
+	if size := len(tx.Serialize()); size > params.MaxTxSize {
+		return fmt.Errorf("transaction size %d exceeds maximum %d", size, params.MaxTxSize)
+	}
+	for _, out := range tx.Vout {
+		if out.Value < 0 {
+			return fmt.Errorf("negative output value %d", out.Value)
+		}
+	}
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Vin {
+			if len(in.Txid) == 0 || in.Vout < 0 {
+				return fmt.Errorf("invalid input reference")
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateBlock checks block received from peer before it is added to chain.
+// Blocks breaking consensus rules are reported as misbehavior.
+func (chain *Blockchain) ValidateBlock(block *Block) error {
+	if !bytes.Equal(block.Hash, block.computeHash()) {
+		return misbehavior(ScoreInvalidBlock, "block hash mismatch [hash:%x]", block.Hash)
+	}
+	if count := len(block.Transactions); count > chain.Params.MaxBlockTransactions {
+		return misbehavior(ScoreInvalidBlock, "block has %d transactions, maximum is %d [hash:%x]", count, chain.Params.MaxBlockTransactions, block.Hash)
+	}
+	if size := len(block.Serialize()); size > chain.Params.MaxBlockSize {
+		return misbehavior(ScoreInvalidBlock, "block size %d exceeds maximum %d [hash:%x]", size, chain.Params.MaxBlockSize, block.Hash)
+	}
+	if limit := chain.Time.Now().Add(chain.Params.MaxFutureBlockTime).Unix(); block.Timestamp > limit {
+		return fmt.Errorf("block timestamp %d is too far in the future [hash:%x]", block.Timestamp, block.Hash)
+	}
+	if checkpoint, ok := chain.Params.Checkpoint(block.Height); ok && hex.EncodeToString(block.Hash) != checkpoint.Hash {
+		return misbehavior(ScoreInvalidBlock, "block at height %d does not match checkpoint [hash:%x]", block.Height, block.Hash)
+	}
+	if assumed := chain.Params.AssumeValid; chain.AssumeValid && assumed.Hash != "" && block.Height == assumed.Height &&
+		hex.EncodeToString(block.Hash) != assumed.Hash {
+		return misbehavior(ScoreInvalidBlock, "block at height %d does not match assume valid block [hash:%x]", block.Height, block.Hash)
+	}
+	if checkpoint := chain.LastCheckpoint(); block.Height <= checkpoint && !chain.HasBlock(block.Hash) {
+		return misbehavior(ScoreInvalidBlock, "block at height %d forks chain below checkpoint at height %d [hash:%x]", block.Height, checkpoint, block.Hash)
+	}
+	if len(block.PrevBlockHash) == 0 {
+		if block.Height != 0 || !chain.Params.IsGenesis(block.Hash) {
+			return misbehavior(ScoreInvalidBlock, "block without parent is not genesis of %s network [hash:%x]", chain.Params.Name, block.Hash)
+		}
+	} else {
+		err := chain.Engine.VerifySeal(chain, block)
+		if err != nil {
+			return err
+		}
+		parent, err := chain.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			return fmt.Errorf("parent of block %x not found", block.Hash)
+		}
+		if block.Height != parent.Height+1 {
+			return misbehavior(ScoreInvalidBlock, "invalid block height %d", block.Height)
+		}
+		if mtp := chain.MedianTimePast(block.PrevBlockHash); block.Timestamp <= mtp {
+			return misbehavior(ScoreInvalidBlock, "block timestamp %d is not after median time past %d [hash:%x]", block.Timestamp, mtp, block.Hash)
+		}
+	}
+	assumeValid := chain.assumesValid(block)
+	view := chain.utxoView(block.PrevBlockHash)
+	fees, reward := 0, 0
+	for _, tx := range block.Transactions {
+		err := CheckTransactionSanity(tx, chain.Params)
+		if err != nil {
+			return misbehavior(ScoreInvalidBlock, "invalid transaction %x: %s", tx.ID, err)
+		}
+		if view.has(tx.ID) {
+			return misbehavior(ScoreInvalidBlock, "transaction %x overwrites unspent outputs", tx.ID)
+		}
+		if tx.IsCoinbase() {
+			reward += outputsValue(tx)
+			view.add(tx)
+			continue
+		}
+		previousTxs := make(map[string]Transaction)
+		in := 0
+		for _, vin := range tx.Vin {
+			out, ok := view.spend(vin.Txid, vin.Vout)
+			if !ok {
+				return misbehavior(ScoreInvalidBlock, "transaction %x spends missing or spent output", tx.ID)
+			}
+			in += out.Value
+			addPreviousOutput(previousTxs, vin.Txid, vin.Vout, out)
+		}
+		if !assumeValid && !tx.Verify(previousTxs) {
+			return misbehavior(ScoreInvalidBlock, "transaction %x failed verification", tx.ID)
+		}
+		if out := outputsValue(tx); out > in {
+			return misbehavior(ScoreInvalidBlock, "transaction %x outputs %d exceed inputs %d", tx.ID, out, in)
+		}
+		fees += in - outputsValue(tx)
+		view.add(tx)
+	}
+	subsidy := chain.Params.BlockSubsidy(block.Height)
+	if reward > subsidy+fees {
+		return misbehavior(ScoreInvalidBlock, "coinbase pays %d, more than subsidy %d and fees %d", reward, subsidy, fees)
+	}
+	if assumeValid && hex.EncodeToString(block.Hash) != chain.Params.AssumeValid.Hash {
+		chain.mu.Lock()
+		chain.unverified[hex.EncodeToString(block.Hash)] = true
+		chain.mu.Unlock()
+	}
+	return nil
+}
+
+// assumesValid checks if signatures of block may be skipped: assume valid is enabled, node is in initial
+// block download and block is not above assume valid block of network, which has to match its hash.
+// Block validated without signatures is marked unverified, it joins main chain only when assume valid block
+// connects on top of it, so signatures are skipped only for ancestors of assume valid block.
+// Once assume valid block is stored its ancestors are known, so signatures of later blocks are always checked.
+func (chain *Blockchain) assumesValid(block *Block) bool {
+	assumed := chain.Params.AssumeValid
+	if !chain.AssumeValid || assumed.Hash == "" || block.Height > assumed.Height {
+		return false
+	}
+	hash, err := hex.DecodeString(assumed.Hash)
+	if err != nil || chain.HasBlock(hash) {
+		return false
+	}
+	return chain.IsInitialBlockDownload()
+}
+
+// utxoView holds unspent outputs of chain ending with parent of validated block.
+// Outputs of main chain tip are looked up in utxo index, outputs of side branch are collected from its blocks.
+// Outputs created and spent by transactions of validated block are tracked as they are checked.
+type utxoView struct {
+	store   *UtxoStore
+	outputs map[string]TxOutputs
+	spent   map[string]bool
+}
+
+// utxoView creates view of unspent outputs of chain ending with block of given hash
+func (chain *Blockchain) utxoView(hash []byte) *utxoView {
+	view := &utxoView{outputs: make(map[string]TxOutputs), spent: make(map[string]bool)}
+	if tip, _ := chain.Tip(); bytes.Equal(tip, hash) {
+		view.store = &UtxoStore{chain}
+		return view
+	}
+	if len(hash) == 0 {
+		return view
+	}
+	chain.db.View(func(tx *bolt.Tx) error {
+		view.outputs = findUtxo(tx.Bucket([]byte(chain.config.GetDbBucket())), hash)
+		return nil
+	})
+	return view
+}
+
+// has checks if transaction has unspent outputs
+func (view *utxoView) has(txid []byte) bool {
+	if len(view.outputs[hex.EncodeToString(txid)]) > 0 {
+		return true
+	}
+	return view.store != nil && view.store.HasOutputs(txid)
+}
+
+// spend takes unspent output, returns false when output does not exist or is already spent
+func (view *utxoView) spend(txid []byte, vout int) (TxOutput, bool) {
+	key := outpoint(txid, vout)
+	if view.spent[key] {
+		return TxOutput{}, false
+	}
+	out, ok := view.outputs[hex.EncodeToString(txid)][vout]
+	if !ok && view.store != nil {
+		out, ok = view.store.FindOutput(txid, vout)
+	}
+	if ok {
+		view.spent[key] = true
+	}
+	return out, ok
+}
+
+// add adds outputs of transaction
+func (view *utxoView) add(tx *Transaction) {
+	outs := make(TxOutputs)
+	for i, out := range tx.Vout {
+		outs[i] = out
+	}
+	view.outputs[hex.EncodeToString(tx.ID)] = outs
+}
+
+// addPreviousOutput adds output spent by input to previous transactions used to verify input signature
+func addPreviousOutput(previousTxs map[string]Transaction, txid []byte, vout int, out TxOutput) {
+	prevID := hex.EncodeToString(txid)
+	prev := previousTxs[prevID]
+	for len(prev.Vout) <= vout {
+		prev.Vout = append(prev.Vout, TxOutput{})
+	}
+	prev.Vout[vout] = out
+	previousTxs[prevID] = prev
+}
+
+// outputsValue sums values of transaction outputs
+func outputsValue(tx *Transaction) int {
+	value := 0
+	for _, out := range tx.Vout {
+		value += out.Value
+	}
+	return value
+}
+
+// computeHash recomputes block hash from its contents and nonce
+func (block *Block) computeHash() []byte {
+	hash := sha256.Sum256(block.join(block.Nonce))
+	return hash[:]
+}
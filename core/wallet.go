@@ -5,65 +5,106 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-
-	"github.com/mr-tron/base58/base58"
 )
 
-// Version protocol version
-const Version = byte(0x01)
-
 // AddressChecksumLength checksum length
 const AddressChecksumLength = 4
 
-// Wallet struct
+// Wallet struct, addresses of wallet belong to Network, main network when it is empty
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	Network    string
 }
 
-// NewWallet creates new wallet
+// NewWallet creates new wallet of main network
 func NewWallet() *Wallet {
+	return NewNetworkWallet(&MainParams)
+}
+
+// NewNetworkWallet creates new wallet of network
+func NewNetworkWallet(params *ChainParams) *Wallet {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		panic(err)
 	}
 	publicK := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	wallet := &Wallet{*private, publicK}
+	wallet := &Wallet{*private, publicK, params.Name}
 	return wallet
 }
 
+// Params gets parameters of wallet network
+func (wallet *Wallet) Params() *ChainParams {
+	params, err := ParamsForNetwork(wallet.Network)
+	if err != nil {
+		return &MainParams
+	}
+	return params
+}
+
 // GetAddress gets the wallet address
 func (wallet *Wallet) GetAddress() []byte {
-	return GetAddressFromPublicKey(wallet.PublicKey)
+	return GetAddressFromPublicKey(wallet.Params(), wallet.PublicKey)
 }
 
-// GetAddressFromPublicKey gets address from public key
-func GetAddressFromPublicKey(publicKey []byte) []byte {
+// GetAddressFromPublicKey gets address of network from public key
+func GetAddressFromPublicKey(params *ChainParams, publicKey []byte) []byte {
 	pubKeyHash := RipeMd160Sha256(publicKey)
-	return GetAddressFromPublicKeyHash(pubKeyHash)
+	return GetAddressFromPublicKeyHash(params, pubKeyHash)
 }
 
-// GetAddressFromPublicKeyHash gets address from public key hash
-func GetAddressFromPublicKeyHash(publicKeyHash []byte) []byte {
-	versionedPublicK := append([]byte{Version}, publicKeyHash...)
-	checksum := ShaChecksum(versionedPublicK, AddressChecksumLength)
-	address := base58.Encode(append(versionedPublicK, checksum...))
-	return []byte(address)
+// GetAddressFromPublicKeyHash gets address of network from public key hash
+func GetAddressFromPublicKeyHash(params *ChainParams, publicKeyHash []byte) []byte {
+	return []byte(params.EncodeAddress(publicKeyHash))
 }
 
-// IsValidAddress validates wallet address
+// IsValidAddress validates wallet address on wallet network
 func (wallet *Wallet) IsValidAddress() bool {
-	address := wallet.GetAddress()
-	publicK, err := base58.Decode(string(address))
-	if err != nil {
-		panic(err)
+	_, err := wallet.Params().DecodeAddress(string(wallet.GetAddress()))
+	return err == nil
+}
+
+// BumpFee makes replacement of pending transaction paying extra fee from change output of wallet.
+// Transaction has to signal replace-by-fee and spend only outputs of wallet.
+func (wallet *Wallet) BumpFee(tx Transaction, extra int) (*Transaction, error) {
+	if !tx.SignalsReplacement() {
+		return nil, fmt.Errorf("transaction %x does not signal replace-by-fee", tx.ID)
+	}
+	if extra <= 0 {
+		return nil, fmt.Errorf("fee increase must be positive")
+	}
+	pubKeyHash := RipeMd160Sha256(wallet.PublicKey)
+	replacement := Transaction{nil, make([]TxInput, len(tx.Vin)), append([]TxOutput{}, tx.Vout...)}
+	previousTxs := make(map[string]Transaction)
+	for i, in := range tx.Vin {
+		if !bytes.Equal(in.PubKey, wallet.PublicKey) {
+			return nil, fmt.Errorf("input %d of transaction %x is not spent by wallet", i, tx.ID)
+		}
+		replacement.Vin[i] = TxInput{in.Txid, in.Vout, nil, in.PubKey, in.Sequence}
+		prevID := hex.EncodeToString(in.Txid)
+		prev := previousTxs[prevID]
+		for len(prev.Vout) <= in.Vout {
+			prev.Vout = append(prev.Vout, TxOutput{})
+		}
+		prev.Vout[in.Vout] = TxOutput{0, pubKeyHash}
+		previousTxs[prevID] = prev
+	}
+	change := -1
+	for i, out := range replacement.Vout {
+		if out.CanOutputBeUnlocked(pubKeyHash) && out.Value >= extra {
+			change = i
+		}
+	}
+	if change < 0 {
+		return nil, fmt.Errorf("no change output of wallet can pay extra fee %d", extra)
 	}
-	checksum := publicK[len(publicK)-AddressChecksumLength:]
-	publicKeyHash := publicK[0 : len(publicK)-AddressChecksumLength]
-	requiredChecksum := ShaChecksum(publicKeyHash, AddressChecksumLength)
-	return bytes.Compare(checksum, requiredChecksum) == 0
+	replacement.Vout[change].Value -= extra
+	replacement.ID = replacement.Hash()
+	replacement.Sign(&wallet.PrivateKey, previousTxs)
+	return &replacement, nil
 }
 
 // Log prints block info
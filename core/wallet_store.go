@@ -36,9 +36,13 @@ func (ws *WalletStore) GetWallet(address string) *Wallet {
 	return ws.Wallets[address]
 }
 
-// CreateWallet creates and saves wallet to file
+// CreateWallet creates wallet of configured network and saves it to file
 func (ws *WalletStore) CreateWallet() *Wallet {
-	wallet := NewWallet()
+	params, err := ParamsForNetwork(ws.Config.GetNetwork())
+	if err != nil {
+		panic(err)
+	}
+	wallet := NewNetworkWallet(params)
 	address := string(wallet.GetAddress())
 	if ws.Wallets == nil {
 		ws.Wallets = make(map[string]*Wallet)
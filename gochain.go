@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/qza/gochain/core"
@@ -17,6 +21,10 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	params, err := core.ParamsForNetwork(env.GetNetwork())
+	if err != nil {
+		log.Fatal(err)
+	}
 	app := cli.NewApp()
 	app.Name = "gochain"
 	app.Usage = "gochain help"
@@ -32,13 +40,9 @@ func main() {
 					Action: func(c *cli.Context) error {
 						nodeID := c.Args().Get(0)
 						os.RemoveAll(env.GetDbFile(nodeID))
-						wstore := core.NewWalletStore(env, nodeID)
-						wstore.Load(env.GetWalletStoreFile(nodeID))
-						wallet := wstore.CreateWallet()
-						address := string(wallet.GetAddress())
-						core.InitChain(env, address, nodeID)
-						log.Printf("genesis wallet")
-						wallet.Log()
+						chain := core.InitChain(env, nodeID)
+						log.Printf("chain initialized with %s network genesis %s", params.Name, params.GenesisHash)
+						chain.Close()
 						return nil
 					},
 				},
@@ -91,6 +95,31 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "generate",
+			Usage: "immediately mines n blocks on running regtest node, optional reward address",
+			Action: func(c *cli.Context) error {
+				nodeID := c.Args().Get(0)
+				count, err := strconv.Atoi(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				client, err := core.DialRPC(env, nodeID)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+				var reply core.GenerateReply
+				err = client.Call("Node.Generate", &core.GenerateArgs{Count: count, Address: c.Args().Get(2)}, &reply)
+				if err != nil {
+					return err
+				}
+				for _, hash := range reply.Hashes {
+					fmt.Println(hash)
+				}
+				return nil
+			},
+		},
 		{
 			Name:    "wallet",
 			Aliases: []string{"w"},
@@ -149,6 +178,82 @@ func main() {
 						return nil
 					},
 				},
+				{
+					Name:  "send",
+					Usage: "sends amount from wallet to address through running node, optional fee, --rbf allows bumping the fee",
+					Flags: []cli.Flag{
+						cli.BoolFlag{Name: "rbf", Usage: "signal that transaction may be replaced by one paying higher fee"},
+					},
+					Action: func(c *cli.Context) error {
+						nodeID := c.Args().Get(0)
+						amount, err := strconv.Atoi(c.Args().Get(3))
+						if err != nil {
+							return err
+						}
+						fee := 0
+						if c.Args().Get(4) != "" {
+							fee, err = strconv.Atoi(c.Args().Get(4))
+							if err != nil {
+								return err
+							}
+						}
+						client, err := core.DialRPC(env, nodeID)
+						if err != nil {
+							return err
+						}
+						defer client.Close()
+						args := &core.SendArgs{From: c.Args().Get(1), To: c.Args().Get(2), Amount: amount, Fee: fee, Replaceable: c.Bool("rbf")}
+						var reply core.TransactionReply
+						err = client.Call("Node.Send", args, &reply)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("transaction %s pending [fee:%d]\n", reply.ID, reply.Fee)
+						return nil
+					},
+				},
+				{
+					Name:  "bumpfee",
+					Usage: "replaces pending transaction of wallet with one paying given extra fee",
+					Action: func(c *cli.Context) error {
+						nodeID := c.Args().Get(0)
+						extra, err := strconv.Atoi(c.Args().Get(2))
+						if err != nil {
+							return err
+						}
+						client, err := core.DialRPC(env, nodeID)
+						if err != nil {
+							return err
+						}
+						defer client.Close()
+						var pending core.TransactionReply
+						err = client.Call("Node.PendingTransaction", &core.TransactionArgs{ID: c.Args().Get(1)}, &pending)
+						if err != nil {
+							return err
+						}
+						tx, err := core.DecodeTransaction(pending.Transaction)
+						if err != nil {
+							return err
+						}
+						wstore := core.NewWalletStore(env, nodeID)
+						wstore.Load(env.GetWalletStoreFile(nodeID))
+						wallet := wstore.GetWallet(string(core.GetAddressFromPublicKey(params, tx.Vin[0].PubKey)))
+						if wallet == nil {
+							return fmt.Errorf("wallet spending transaction %s not found", pending.ID)
+						}
+						replacement, err := wallet.BumpFee(tx, extra)
+						if err != nil {
+							return err
+						}
+						var reply core.TransactionReply
+						err = client.Call("Node.SendTransaction", &core.TransactionArgs{Transaction: replacement.Serialize()}, &reply)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("transaction %s replaced by %s [fee:%d -> %d]\n", pending.ID, reply.ID, pending.Fee, reply.Fee)
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -159,15 +264,132 @@ func main() {
 				{
 					Name:  "start",
 					Usage: "starts new node",
+					Flags: []cli.Flag{
+						cli.BoolFlag{Name: "no-assume-valid", Usage: "verify signatures of all blocks during initial sync"},
+					},
 					Action: func(c *cli.Context) error {
 						port := c.Args().Get(0)
+						if port == "" {
+							port = params.DefaultPort
+						}
 						minersAddress := c.Args().Get(2)
 						node := core.NewNode(env, port, minersAddress)
-						node.Start()
-						log.Printf("node started on port %s \n", port)
+						node.Chain.AssumeValid = !c.Bool("no-assume-valid")
+						ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+						defer stop()
+						log.Printf("starting node on port %s of %s network\n", port, params.Name)
+						return node.Start(ctx)
+					},
+				},
+				{
+					Name:  "peers",
+					Usage: "lists peers connected to running node",
+					Action: func(c *cli.Context) error {
+						nodeID := c.Args().Get(0)
+						client, err := core.DialRPC(env, nodeID)
+						if err != nil {
+							return err
+						}
+						defer client.Close()
+						var reply core.PeersReply
+						err = client.Call("Node.Peers", &core.PeersArgs{}, &reply)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("peers connected to node %s: %d\n", nodeID, len(reply.Peers))
+						for _, p := range reply.Peers {
+							direction := "outbound"
+							if p.Inbound {
+								direction = "inbound"
+							}
+							fmt.Printf("%s [%s] [version:%d] [agent:%s] [height:%d] [latency:%s] [connected:%s] "+
+								"[sent:%d msgs/%d bytes] [received:%d msgs/%d bytes] [banscore:%d]\n",
+								p.Addr, direction, p.Version, p.UserAgent, p.StartHeight, p.Latency,
+								time.Unix(p.ConnectedAt, 0).Format(time.RFC3339),
+								p.MessagesSent, p.BytesSent, p.MessagesReceived, p.BytesReceived, p.BanScore)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "template",
+					Usage: "gets block template for external miner from running node, optional reward address",
+					Action: func(c *cli.Context) error {
+						nodeID := c.Args().Get(0)
+						client, err := core.DialRPC(env, nodeID)
+						if err != nil {
+							return err
+						}
+						defer client.Close()
+						var reply core.BlockTemplateReply
+						err = client.Call("Node.GetBlockTemplate", &core.BlockTemplateArgs{Address: c.Args().Get(1)}, &reply)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("template %s [height:%d] [prev:%x] [timestamp:%d] [target:%d] [transactions:%d] "+
+							"[coinbase:%d] [fees:%d]\nheader: %x\n", reply.ID, reply.Height, reply.PrevBlockHash,
+							reply.Timestamp, reply.Target, len(reply.Transactions), reply.CoinbaseValue, reply.Fees, reply.Header)
+						return nil
+					},
+				},
+				{
+					Name:  "submit",
+					Usage: "submits solved block template with its timestamp and nonce to running node",
+					Action: func(c *cli.Context) error {
+						nodeID := c.Args().Get(0)
+						timestamp, err := strconv.ParseInt(c.Args().Get(2), 10, 64)
+						if err != nil {
+							return err
+						}
+						nonce, err := strconv.Atoi(c.Args().Get(3))
+						if err != nil {
+							return err
+						}
+						client, err := core.DialRPC(env, nodeID)
+						if err != nil {
+							return err
+						}
+						defer client.Close()
+						var reply core.SubmitBlockReply
+						err = client.Call("Node.SubmitBlock", &core.SubmitBlockArgs{ID: c.Args().Get(1), Timestamp: timestamp, Nonce: nonce}, &reply)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("block %s accepted [height:%d]\n", reply.Hash, reply.Height)
 						return nil
 					},
 				},
+				{
+					Name:  "key",
+					Usage: "prints fingerprint of node transport key, generating the key if needed",
+					Action: func(c *cli.Context) error {
+						nodeID := c.Args().Get(0)
+						key, err := core.LoadNodeKey(env.GetNodeKeyFile(nodeID))
+						if err != nil {
+							return err
+						}
+						fingerprint, err := core.KeyFingerprint(&key.PublicKey)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("node %s key: %s\n", nodeID, fingerprint)
+						return nil
+					},
+				},
+				{
+					Name:  "ban",
+					Usage: "bans peer address (host or host:port) on running node, optional duration like 1h",
+					Action: func(c *cli.Context) error {
+						return callBan(env, "Node.Ban", c.Args().Get(0), c.Args().Get(1), c.Args().Get(2))
+					},
+				},
+				{
+					Name:  "unban",
+					Usage: "removes ban of peer address on running node",
+					Action: func(c *cli.Context) error {
+						return callBan(env, "Node.Unban", c.Args().Get(0), c.Args().Get(1), "")
+					},
+				},
 			},
 		},
 	}
@@ -176,3 +398,21 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func callBan(env core.Config, method, nodeID, address, duration string) error {
+	client, err := core.DialRPC(env, nodeID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	var reply core.BanReply
+	err = client.Call(method, &core.BanArgs{Address: address, Duration: duration}, &reply)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("banned addresses on node %s: %d\n", nodeID, len(reply.Bans))
+	for _, ban := range reply.Bans {
+		fmt.Printf("%s [until:%s]\n", ban.Addr, time.Unix(ban.Until, 0).Format(time.RFC3339))
+	}
+	return nil
+}